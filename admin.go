@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// adminSecrets are config fields /admin/config never shows
+var adminSecrets = []string{"BridgeToken", "LocalOpenclawToken", "GoogleTTSAPIKey", "AdminToken", "RPCToken"}
+
+// ActiveRequest is a chat request a bridge is serving
+type ActiveRequest struct {
+	RequestID     string    `json:"requestId"`
+	BridgeID      string    `json:"bridgeId"`
+	BridgeName    string    `json:"bridgeName"`
+	StartedAt     time.Time `json:"startedAt"`
+	BufferedBytes int       `json:"bufferedBytes"` // waiting for the app
+}
+
+// ActiveRequests lists the chat requests in flight on all bridges, oldest first
+func (bm *BridgeManager) ActiveRequests() []ActiveRequest {
+	bm.mutex.RLock()
+	defer bm.mutex.RUnlock()
+
+	requests := []ActiveRequest{}
+	for _, bridge := range bm.connections {
+		bridge.requestMu.RLock()
+		for id, stream := range bridge.requestChans {
+			requests = append(requests, ActiveRequest{
+				RequestID:     id,
+				BridgeID:      bridge.ID,
+				BridgeName:    bridge.Name,
+				StartedAt:     stream.started,
+				BufferedBytes: stream.Buffered(),
+			})
+		}
+		bridge.requestMu.RUnlock()
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].StartedAt.Before(requests[j].StartedAt)
+	})
+	return requests
+}
+
+// Kick disconnects a bridge; its requests end with "bridge disconnected"
+func (bm *BridgeManager) Kick(id string) bool {
+	bridge := bm.GetBridge(id)
+	if bridge == nil {
+		return false
+	}
+	log.Printf("Kicking bridge %s (%s)", bridge.Name, bridge.ID)
+	bridge.Close()
+	return true
+}
+
+// handleAdmin serves /admin/... for the `admin` CLI. It requires
+// "Authorization: Bearer <ADMIN_TOKEN>" and is disabled without ADMIN_TOKEN.
+//
+//	GET    /admin/bridges           connected bridges
+//	DELETE /admin/bridges/{id}      disconnect a bridge
+//	GET    /admin/requests          chat requests in flight
+//	GET    /admin/notifications     connected notification clients
+//	POST   /admin/youtube/flush     drop YouTube stream caches
+//	GET    /admin/devices           registered FCM devices
+//	DELETE /admin/devices/{id}      revoke a device's FCM token
+//	GET    /admin/config            configuration, secrets hidden
+func (api *APIServer) handleAdmin(w http.ResponseWriter, r *http.Request) {
+	if !api.adminAuthorized(w, r) {
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/"), "/"), "/")
+	route := r.Method + " " + parts[0]
+	if len(parts) == 2 {
+		route += "/{id}"
+	}
+
+	switch route {
+	case "GET bridges":
+		writeAdminJSON(w, api.bridgeManager.GetInstances())
+
+	case "DELETE bridges/{id}":
+		if !api.bridgeManager.Kick(parts[1]) {
+			http.Error(w, "Bridge not found", http.StatusNotFound)
+			return
+		}
+		writeAdminJSON(w, map[string]string{"kicked": parts[1]})
+
+	case "GET requests":
+		writeAdminJSON(w, api.bridgeManager.ActiveRequests())
+
+	case "GET notifications":
+		writeAdminJSON(w, api.notifyHub.Clients())
+
+	case "POST youtube/{id}":
+		if parts[1] != "flush" {
+			http.NotFound(w, r)
+			return
+		}
+		streamInfos, hlsURLs := flushYouTubeCaches()
+		log.Printf("[Admin] Flushed YouTube caches (%d stream infos, %d HLS URLs)", streamInfos, hlsURLs)
+		writeAdminJSON(w, map[string]int{"streamInfos": streamInfos, "hlsUrls": hlsURLs})
+
+	case "GET devices":
+		writeAdminJSON(w, api.fcmManager.Tokens())
+
+	case "DELETE devices/{id}":
+		if !api.fcmManager.RevokeToken(parts[1]) {
+			http.Error(w, "Device not found", http.StatusNotFound)
+			return
+		}
+		writeAdminJSON(w, map[string]string{"revoked": parts[1]})
+
+	case "GET config":
+		writeAdminJSON(w, api.redactedConfig())
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// adminAuthorized checks "Authorization: Bearer <ADMIN_TOKEN>", answering
+// 404 while ADMIN_TOKEN is unset and 401 for a wrong token
+func (api *APIServer) adminAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	if api.config.AdminToken == "" {
+		http.NotFound(w, r)
+		return false
+	}
+	token, err := ExtractBearerToken(r)
+	if err != nil || subtle.ConstantTimeCompare([]byte(token), []byte(api.config.AdminToken)) != 1 {
+		log.Printf("[Admin] Unauthorized %s %s from %s", r.Method, r.URL.Path, api.clientIP(r))
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// redactedConfig returns the configuration with secrets replaced by "***"
+func (api *APIServer) redactedConfig() map[string]interface{} {
+	data, _ := json.Marshal(api.config)
+	var config map[string]interface{}
+	json.Unmarshal(data, &config)
+	for _, key := range adminSecrets {
+		if v, _ := config[key].(string); v != "" {
+			config[key] = "***"
+		}
+	}
+	return config
+}
+
+func writeAdminJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// adminCommand maps a CLI command to an /admin request
+type adminCommand struct {
+	method, path string
+	arg          string // name of the required argument appended to path, if any
+	help         string
+}
+
+var adminCommands = map[string]adminCommand{
+	"bridges":       {http.MethodGet, "bridges", "", "list connected bridges"},
+	"kick":          {http.MethodDelete, "bridges", "bridge-id", "disconnect a bridge"},
+	"requests":      {http.MethodGet, "requests", "", "list chat requests in flight"},
+	"clients":       {http.MethodGet, "notifications", "", "list connected notification clients"},
+	"youtube-flush": {http.MethodPost, "youtube/flush", "", "drop YouTube stream caches"},
+	"devices":       {http.MethodGet, "devices", "", "list registered FCM devices"},
+	"revoke":        {http.MethodDelete, "devices", "instance-id", "revoke a device's FCM token"},
+	"config":        {http.MethodGet, "config", "", "show the configuration (secrets hidden)"},
+}
+
+var adminCommandOrder = []string{"bridges", "kick", "requests", "clients", "youtube-flush", "devices", "revoke", "config"}
+
+// runAdmin implements `voicechat-server admin [flags] <command> [arg]`,
+// calling the running server's /admin API. It returns the exit code.
+func runAdmin(args []string) int {
+	config := LoadConfig()
+	scheme := "http"
+	if config.TLSEnabled {
+		scheme = "https"
+	}
+
+	fs := flag.NewFlagSet("admin", flag.ContinueOnError)
+	server := fs.String("url", fmt.Sprintf("%s://localhost:%d", scheme, config.Port), "server base URL")
+	token := fs.String("token", "", "admin token (default $ADMIN_TOKEN)")
+	insecure := fs.Bool("insecure", false, "skip TLS certificate verification")
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: voicechat-server admin [flags] <command> [arg]\n\nCommands:\n")
+		for _, name := range adminCommandOrder {
+			cmd := adminCommands[name]
+			usage := name
+			if cmd.arg != "" {
+				usage += " <" + cmd.arg + ">"
+			}
+			fmt.Fprintf(out, "  %-26s %s\n", usage, cmd.help)
+		}
+		fmt.Fprintf(out, "\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cmd, ok := adminCommands[fs.Arg(0)]
+	if !ok || (cmd.arg != "") != (fs.NArg() == 2) || fs.NArg() > 2 {
+		fs.Usage()
+		return 2
+	}
+	if *token == "" {
+		*token = config.AdminToken
+	}
+	if *token == "" {
+		fmt.Fprintln(os.Stderr, "admin: no token, set ADMIN_TOKEN or -token")
+		return 2
+	}
+
+	path := cmd.path
+	if cmd.arg != "" {
+		path += "/" + url.PathEscape(fs.Arg(1))
+	}
+	req, err := http.NewRequest(cmd.method, *server+"/admin/"+path, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "admin: %v\n", err)
+		return 1
+	}
+	req.Header.Set("Authorization", "Bearer "+*token)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	if *insecure {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "admin: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "admin: %s: %s\n", resp.Status, bytes.TrimSpace(body))
+		return 1
+	}
+	var out bytes.Buffer
+	if json.Indent(&out, body, "", "  ") != nil {
+		out.Reset()
+		out.Write(body)
+	}
+	fmt.Println(string(bytes.TrimSpace(out.Bytes())))
+	return 0
+}
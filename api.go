@@ -2,7 +2,9 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -33,6 +35,13 @@ type APIServer struct {
 	fcmManager         *FcmManager
 	conversationStore  *ConversationStore
 	apkHandler         *APKHandler
+	summarizer         *Summarizer // nil unless SUMMARIZE_ENABLED
+	storage            *StorageManager
+	fileStore          *FileStore
+	usage              *UsageLedger
+	limiters           map[string]*RateLimiter // by limit class
+	queue              *OfflineQueue
+	wake               *WakeManager
 }
 
 // NewAPIServer creates a new API server
@@ -45,16 +54,53 @@ func NewAPIServer(bridgeManager *BridgeManager, relayManager *RelayManager, conf
 	}
 	fcmMgr := NewFcmManager(config.DataDir, fcmSAPath)
 
-	return &APIServer{
+	conversationStore := NewConversationStore(config.DataDir)
+	apkHandler := NewAPKHandler(config.DataDir)
+
+	fileStore := NewFileStore(config.DataDir, config)
+	bridgeManager.fileStore = fileStore
+
+	storage := NewStorageManager(config, conversationStore, apkHandler, fcmMgr, fileStore)
+	apkHandler.storage = storage
+	fileStore.storage = storage
+	if config.JanitorIntervalMinutes > 0 {
+		go storage.StartJanitor(time.Duration(config.JanitorIntervalMinutes) * time.Minute)
+	}
+
+	usage := NewUsageLedger(config.DataDir, config)
+
+	var summarizer *Summarizer
+	if config.SummarizeEnabled {
+		summarizer = NewSummarizer(conversationStore, relayManager, usage, config)
+		log.Printf("[Summarize] Enabled (fallback instance=%q, every=%d)", config.SummarizeInstance, config.SummaryEvery)
+	}
+
+	api := &APIServer{
 		bridgeManager:     bridgeManager,
 		relayManager:      relayManager,
 		config:            config,
 		sttProxy:          NewSTTProxy("ws://127.0.0.1:2700"),
 		notifyHub:         NewNotificationHub(),
 		fcmManager:        fcmMgr,
-		conversationStore: NewConversationStore(config.DataDir),
-		apkHandler:        NewAPKHandler(config.DataDir),
+		conversationStore: conversationStore,
+		apkHandler:        apkHandler,
+		summarizer:        summarizer,
+		storage:           storage,
+		fileStore:         fileStore,
+		usage:             usage,
+		limiters:          newRateLimiters(config),
 	}
+
+	api.queue = NewOfflineQueue(config.DataDir, config, bridgeManager, relayManager, conversationStore, usage, func(instanceID, title, body string) {
+		api.sendNotification(instanceID, title, body)
+	})
+	api.wake = NewWakeManager(config.DataDir, config, bridgeManager)
+	bridgeManager.onRegister = func(bridge *BridgeConnection) {
+		api.wake.BridgeRegistered(bridge)
+		api.queue.Kick()
+	}
+	bridgeManager.onNotify = api.bridgeNotify
+	return api
 }
 
 // StartHTTPServer starts the HTTP API server
@@ -64,19 +110,29 @@ func (api *APIServer) StartHTTPServer() error {
 	mux.HandleFunc("/", api.cors(api.handleRoot))
 	mux.HandleFunc("/health", api.cors(api.handleHealth))
 	mux.HandleFunc("/api/instances", api.cors(api.handleInstances))
-	mux.HandleFunc("/api/chat", api.cors(api.handleChat))
+	mux.HandleFunc("/api/instances/", api.cors(api.limit(LimitRPC, api.handleInstanceByID)))
+	mux.HandleFunc("/api/chat", api.cors(api.limit(LimitChat, api.handleChat)))
 	mux.HandleFunc("/api/stt/stream", api.sttProxy.Handler())
 	mux.HandleFunc("/api/notifications/ws", api.notifyHub.HandleWebSocket)
+	mux.HandleFunc("/api/bridge/ws", api.handleBridgeWebSocket)
+	mux.HandleFunc("/admin/", api.handleAdmin)
 	mux.HandleFunc("/api/notify", api.cors(api.handleNotify))
 	mux.HandleFunc("/api/fcm/register", api.cors(api.fcmManager.HandleRegister))
-	mux.HandleFunc("/api/fcm/push", api.cors(api.fcmManager.HandleSendPush))
+	mux.HandleFunc("/api/fcm/push", api.cors(api.limit(LimitPush, api.fcmManager.HandleSendPush)))
+	mux.HandleFunc("/api/usage", api.cors(api.usage.HandleUsage))
+	mux.HandleFunc("/api/queue", api.cors(api.queue.HandleQueue))
+	mux.HandleFunc("/api/queue/", api.cors(api.queue.HandleQueueItem))
 	mux.HandleFunc("/api/conversations", api.cors(api.handleConversations))
 	mux.HandleFunc("/api/conversations/", api.cors(api.handleConversationByID))
 	mux.HandleFunc("/api/apk/latest", api.cors(api.apkHandler.HandleLatest))
 	mux.HandleFunc("/api/apk/download", api.cors(api.apkHandler.HandleDownload))
 	mux.HandleFunc("/api/apk/upload", api.cors(api.apkHandler.HandleUpload))
-	mux.HandleFunc("/api/youtube/search", api.cors(api.handleYouTubeSearch))
-	mux.HandleFunc("/api/youtube/stream", api.cors(api.handleYouTubeStream))
+	mux.HandleFunc("/api/storage", api.cors(api.storage.HandleReport))
+	mux.HandleFunc("/api/files", api.cors(api.fileStore.HandleAppUpload))
+	mux.HandleFunc("/api/files/upload", api.cors(api.fileStore.HandleUpload))
+	mux.HandleFunc("/api/files/", api.cors(api.fileStore.HandleServe))
+	mux.HandleFunc("/api/youtube/search", api.cors(api.limit(LimitYouTube, api.handleYouTubeSearch)))
+	mux.HandleFunc("/api/youtube/stream", api.cors(api.limit(LimitYouTube, api.handleYouTubeStream)))
 	mux.HandleFunc("/api/youtube/proxy", api.cors(api.handleYouTubeProxy))
 	mux.HandleFunc("/api/youtube/hls-proxy", api.cors(api.handleYouTubeHLSProxy))
 	mux.HandleFunc("/api/youtube/hls-segment", api.cors(api.handleYouTubeHLSSegment))
@@ -92,11 +148,17 @@ func (api *APIServer) StartHTTPServer() error {
 	return http.ListenAndServe(addr, mux)
 }
 
+// handleBridgeWebSocket GET /api/bridge/ws - bridge connection over WebSocket,
+// for networks that block the bridge TCP port
+func (api *APIServer) handleBridgeWebSocket(w http.ResponseWriter, r *http.Request) {
+	api.bridgeManager.ServeWebSocket(w, r, api.clientIP(r))
+}
+
 // cors wraps a handler with CORS headers
 func (api *APIServer) cors(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Range, X-Device-ID")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
@@ -133,6 +195,9 @@ func (api *APIServer) handleRoot(w http.ResponseWriter, r *http.Request) {
 			"/api/apk/latest",
 			"/api/apk/download",
 			"/api/apk/upload",
+			"/api/storage",
+			"/api/files",
+			"/api/files/upload",
 			"/api/youtube/search",
 		},
 		"timestamp": time.Now().UTC(),
@@ -170,14 +235,14 @@ func (api *APIServer) handleInstances(w http.ResponseWriter, r *http.Request) {
 
 	// Add local OpenClaw instance if configured
 	if api.config.LocalOpenclawURL != "" {
-		localInstance := BridgeConnection{
+		localInstance := InstanceInfo{
 			ID:          "local",
 			Name:        api.config.LocalOpenclawName,
 			Status:      "online",
 			ConnectedAt: time.Now(),
 		}
 		// Prepend local instance (always first, always online)
-		instances = append([]BridgeConnection{localInstance}, instances...)
+		instances = append([]InstanceInfo{localInstance}, instances...)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -202,15 +267,48 @@ func (api *APIServer) handleChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := api.fileStore.ResolveAttachments(chatReq.Messages); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Remember which instance serves the conversation (used for summarization)
+	if chatReq.ConversationID != "" {
+		api.conversationStore.SetInstance(chatReq.ConversationID, chatReq.InstanceID)
+		if api.summarizer != nil {
+			chatReq.Messages = api.summarizer.CompressContext(chatReq.ConversationID, chatReq.Messages)
+		}
+	}
+
 	// Local OpenClaw instance — direct HTTP proxy
-	if chatReq.InstanceID == "local" && api.config.LocalOpenclawURL != "" {
+	if isLocalTarget(chatReq.InstanceID, api.config) && api.config.LocalOpenclawURL != "" {
 		api.handleLocalChat(w, r, &chatReq)
 		return
 	}
 
-	if api.bridgeManager.GetBridge(chatReq.InstanceID) == nil {
-		http.Error(w, "Instance not found", http.StatusNotFound)
-		return
+	if len(api.bridgeManager.Candidates(chatReq.InstanceID)) == 0 {
+		if chatReq.Queue {
+			api.enqueueChat(w, r, &chatReq)
+			return
+		}
+		// A sleeping bridge: wake it through a waker bridge and hold the request
+		name := api.bridgeManager.DepartedName(chatReq.InstanceID)
+		if name == "" {
+			name = chatReq.InstanceID
+		}
+		if !api.wake.CanWake(name) {
+			http.Error(w, "Instance not found", http.StatusNotFound)
+			return
+		}
+		if err := api.wake.Wake(name); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if !api.wake.WaitFor(r.Context(), name, time.Duration(api.config.WakeTimeoutSeconds)*time.Second) {
+			http.Error(w, "Instance did not wake up in time", http.StatusGatewayTimeout)
+			return
+		}
+		chatReq.InstanceID = name
 	}
 
 	w.Header().Set("Content-Type", "text/event-stream")
@@ -218,13 +316,17 @@ func (api *APIServer) handleChat(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Connection", "keep-alive")
 
 	requestID := generateRequestID()
+	w.Header().Set("X-Request-ID", requestID)
 	log.Printf("Starting chat relay: instance=%s, requestID=%s", chatReq.InstanceID, requestID)
 
 	responseCh := make(chan string)
 	errorCh := make(chan error)
 	fileCh := make(chan FileResponseMessage, 8)
+	eventCh := make(chan ChatEventMessage)
+	var result RelayResult
 
-	go api.relayManager.RelayChat(chatReq.InstanceID, requestID, chatReq.Messages, "", responseCh, errorCh, fileCh)
+	timeouts := api.relayManager.Timeouts(chatReq.Timeouts)
+	go api.relayManager.RelayChat(r.Context(), chatReq.InstanceID, requestID, chatReq.Messages, chatReq.Options(), timeouts, responseCh, errorCh, fileCh, eventCh, &result)
 
 	flusher, ok := w.(http.Flusher)
 	if !ok {
@@ -232,12 +334,40 @@ func (api *APIServer) handleChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var events []ConversationEvent
+	defer func() { api.saveChatEvents(chatReq.ConversationID, events) }()
+
+	keepalive, stopKeepalive := api.sseKeepalive()
+	defer stopKeepalive()
+
+	writeFile := func(fileMsg FileResponseMessage) {
+		fileData, _ := json.Marshal(map[string]interface{}{"file": fileMsg})
+		fmt.Fprintf(w, "data: %s\n\n", string(fileData))
+		flusher.Flush()
+	}
+	// finish writes files still buffered in fileCh before [DONE]. RelayChat
+	// closes fileCh before responseCh and errorCh, so the range ends.
+	finish := func() {
+		if fileCh != nil {
+			for fileMsg := range fileCh {
+				writeFile(fileMsg)
+			}
+		}
+		fmt.Fprintf(w, "data: [DONE]\n\n")
+		flusher.Flush()
+		api.recordUsage(r, &chatReq, requestID, result)
+	}
+
 	for {
 		select {
+		case <-keepalive:
+			// Comment line: ignored by SSE clients, keeps proxies from closing the stream
+			fmt.Fprintf(w, ": keepalive\n\n")
+			flusher.Flush()
+
 		case delta, ok := <-responseCh:
 			if !ok {
-				fmt.Fprintf(w, "data: [DONE]\n\n")
-				flusher.Flush()
+				finish()
 				return
 			}
 			deltaData := map[string]string{"delta": delta}
@@ -245,10 +375,26 @@ func (api *APIServer) handleChat(w http.ResponseWriter, r *http.Request) {
 			fmt.Fprintf(w, "data: %s\n\n", string(dataBytes))
 			flusher.Flush()
 
+		case fileMsg, ok := <-fileCh:
+			if !ok {
+				fileCh = nil
+				continue
+			}
+			writeFile(fileMsg)
+
+		case ev, ok := <-eventCh:
+			if !ok {
+				eventCh = nil
+				continue
+			}
+			events = collectChatEvent(events, ev)
+			if chatReq.Events {
+				writeChatEvent(w, flusher, ev)
+			}
+
 		case err, ok := <-errorCh:
 			if !ok || err == nil {
-				fmt.Fprintf(w, "data: [DONE]\n\n")
-				flusher.Flush()
+				finish()
 				return
 			}
 			errorData := map[string]string{"error": err.Error()}
@@ -265,6 +411,8 @@ func (api *APIServer) handleChat(w http.ResponseWriter, r *http.Request) {
 
 // handleLocalChat proxies chat to local OpenClaw gateway via OpenAI-compatible API
 func (api *APIServer) handleLocalChat(w http.ResponseWriter, r *http.Request, chatReq *ChatRequest) {
+	requestID := generateRequestID()
+	w.Header().Set("X-Request-ID", requestID)
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
@@ -275,25 +423,32 @@ func (api *APIServer) handleLocalChat(w http.ResponseWriter, r *http.Request, ch
 		return
 	}
 
-	// Build OpenAI-compatible request
-	openaiMessages := make([]map[string]string, len(chatReq.Messages))
-	for i, msg := range chatReq.Messages {
-		openaiMessages[i] = map[string]string{
-			"role":    msg.Role,
-			"content": msg.Content,
-		}
-	}
+	// Build OpenAI-compatible request (content parts and attachments included)
+	openaiMessages := api.fileStore.toOpenAIMessages(chatReq.Messages)
 
+	model, agent := "openclaw", "main"
+	if chatReq.Model != "" {
+		model = chatReq.Model
+	}
+	if chatReq.Agent != "" {
+		agent = chatReq.Agent
+	}
 	body := map[string]interface{}{
-		"model":    "openclaw",
+		"model":    model,
 		"stream":   true,
 		"user":     "voicechat-app",
 		"messages": openaiMessages,
+		// Ask for a final chunk with token usage
+		"stream_options": map[string]bool{"include_usage": true},
 	}
 	bodyData, _ := json.Marshal(body)
 
+	timeouts := api.relayManager.Timeouts(chatReq.Timeouts)
+	ctx, cancel := context.WithTimeout(r.Context(), timeouts.Total)
+	defer cancel()
+
 	url := api.config.LocalOpenclawURL + "/v1/chat/completions"
-	httpReq, err := http.NewRequestWithContext(r.Context(), "POST", url, strings.NewReader(string(bodyData)))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(string(bodyData)))
 	if err != nil {
 		errorData, _ := json.Marshal(map[string]string{"error": err.Error()})
 		fmt.Fprintf(w, "data: %s\n\n", errorData)
@@ -301,7 +456,7 @@ func (api *APIServer) handleLocalChat(w http.ResponseWriter, r *http.Request, ch
 		return
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("x-openclaw-agent-id", "main")
+	httpReq.Header.Set("x-openclaw-agent-id", agent)
 	if api.config.LocalOpenclawToken != "" {
 		httpReq.Header.Set("Authorization", "Bearer "+api.config.LocalOpenclawToken)
 	}
@@ -325,10 +480,64 @@ func (api *APIServer) handleLocalChat(w http.ResponseWriter, r *http.Request, ch
 		return
 	}
 
+	var events []ConversationEvent
+	defer func() { api.saveChatEvents(chatReq.ConversationID, events) }()
+	var usage ChatUsage
+	emit := func(ev ChatEventMessage) {
+		ev.Type = MsgTypeChatEvent
+		ev.RequestID = requestID
+		ev.Timestamp = time.Now().UnixMilli()
+		events = collectChatEvent(events, ev)
+		if chatReq.Events {
+			writeChatEvent(w, flusher, ev)
+		}
+	}
+
+	// Read upstream lines in the background so the loop below can time out
+	// and send keepalives while OpenClaw is busy
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := NewLineScanner(resp.Body)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	keepalive, stopKeepalive := api.sseKeepalive()
+	defer stopKeepalive()
+	idle := time.NewTimer(timeouts.FirstByte)
+	defer idle.Stop()
+	timeoutErr := func(msg string) {
+		errorData, _ := json.Marshal(map[string]string{"error": msg})
+		fmt.Fprintf(w, "data: %s\n\n", errorData)
+		flusher.Flush()
+	}
+
 	// Stream SSE from OpenClaw to client, converting format
-	scanner := NewLineScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
+stream:
+	for {
+		var line string
+		select {
+		case <-keepalive:
+			fmt.Fprintf(w, ": keepalive\n\n")
+			flusher.Flush()
+			continue
+		case <-idle.C:
+			timeoutErr("timeout: no response from OpenClaw")
+			return
+		case l, ok := <-lines:
+			if !ok {
+				break stream
+			}
+			line = l
+		}
+		idle.Reset(timeouts.Idle)
+
 		if !strings.HasPrefix(line, "data: ") {
 			continue
 		}
@@ -338,15 +547,48 @@ func (api *APIServer) handleLocalChat(w http.ResponseWriter, r *http.Request, ch
 		}
 
 		var parsed struct {
+			Model string `json:"model"`
+			Usage *struct {
+				PromptTokens     int `json:"prompt_tokens"`
+				CompletionTokens int `json:"completion_tokens"`
+				TotalTokens      int `json:"total_tokens"`
+			} `json:"usage"`
 			Choices []struct {
 				Delta struct {
-					Content string `json:"content"`
+					Content          string `json:"content"`
+					ReasoningContent string `json:"reasoning_content"`
+					ToolCalls        []struct {
+						ID       string `json:"id"`
+						Function struct {
+							Name string `json:"name"`
+						} `json:"function"`
+					} `json:"tool_calls"`
 				} `json:"delta"`
 			} `json:"choices"`
 		}
 		if err := json.Unmarshal([]byte(data), &parsed); err != nil {
 			continue
 		}
+		if parsed.Model != "" {
+			usage.Model = parsed.Model
+		}
+		if parsed.Usage != nil {
+			usage.PromptTokens = parsed.Usage.PromptTokens
+			usage.CompletionTokens = parsed.Usage.CompletionTokens
+			usage.TotalTokens = parsed.Usage.TotalTokens
+		}
+		if len(parsed.Choices) > 0 {
+			delta := parsed.Choices[0].Delta
+			if delta.ReasoningContent != "" {
+				emit(ChatEventMessage{Event: ChatEventThinking, Thinking: &ThinkingEvent{Delta: delta.ReasoningContent}})
+			}
+			// Tool call arguments arrive in later fragments without a name; only the start is reported
+			for _, tc := range delta.ToolCalls {
+				if tc.Function.Name != "" {
+					emit(ChatEventMessage{Event: ChatEventToolStart, Tool: &ToolEvent{CallID: tc.ID, Name: tc.Function.Name}})
+				}
+			}
+		}
 		if len(parsed.Choices) > 0 && parsed.Choices[0].Delta.Content != "" {
 			deltaData, _ := json.Marshal(map[string]string{"delta": parsed.Choices[0].Delta.Content})
 			fmt.Fprintf(w, "data: %s\n\n", string(deltaData))
@@ -354,11 +596,85 @@ func (api *APIServer) handleLocalChat(w http.ResponseWriter, r *http.Request, ch
 		}
 	}
 
+	if r.Context().Err() != nil {
+		return
+	}
+	if ctx.Err() != nil {
+		timeoutErr(fmt.Sprintf("timeout: request exceeded %s", timeouts.Total))
+		return
+	}
+
 	fmt.Fprintf(w, "data: [DONE]\n\n")
 	flusher.Flush()
+	api.recordUsage(r, chatReq, requestID, RelayResult{InstanceID: "local", InstanceName: api.config.LocalOpenclawName, Usage: usage})
 	log.Printf("Local OpenClaw chat completed")
 }
 
+// enqueueChat accepts a chat request for an offline bridge (202 Accepted)
+func (api *APIServer) enqueueChat(w http.ResponseWriter, r *http.Request, chatReq *ChatRequest) {
+	// Bridges get a new ID when they reconnect; wait for the name instead
+	target := chatReq.InstanceID
+	if name := api.bridgeManager.DepartedName(target); name != "" {
+		target = name
+	} else if strings.HasPrefix(target, "bridge_") {
+		http.Error(w, "Instance not found", http.StatusNotFound)
+		return
+	}
+
+	queued, err := api.queue.Enqueue(chatReq, target, deviceID(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The queue delivers it once the woken bridge registers
+	if err := api.wake.Wake(target); err != nil && !errors.Is(err, ErrCannotWake) {
+		log.Printf("[Wake] %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(queued)
+}
+
+// sseKeepalive returns a ticker channel for SSE keepalive comments (nil if
+// disabled) and a function to stop it
+func (api *APIServer) sseKeepalive() (<-chan time.Time, func()) {
+	if api.config.SSEKeepaliveSeconds <= 0 {
+		return nil, func() {}
+	}
+	ticker := time.NewTicker(time.Duration(api.config.SSEKeepaliveSeconds) * time.Second)
+	return ticker.C, ticker.Stop
+}
+
+// recordUsage adds a completed chat request to the usage ledger
+func (api *APIServer) recordUsage(r *http.Request, chatReq *ChatRequest, requestID string, result RelayResult) {
+	usage := result.Usage
+	rec := UsageRecord{
+		RequestID:        requestID,
+		InstanceID:       result.InstanceID,
+		InstanceName:     result.InstanceName,
+		DeviceID:         deviceID(r),
+		ConversationID:   chatReq.ConversationID,
+		Model:            usage.Model,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.TotalTokens,
+		Reported:         usage.PromptTokens+usage.CompletionTokens+usage.TotalTokens > 0,
+	}
+	rec.ClientIP = api.clientIP(r)
+	api.usage.Record(rec)
+}
+
+// saveChatEvents persists the events of a finished chat request
+func (api *APIServer) saveChatEvents(conversationID string, events []ConversationEvent) {
+	if conversationID == "" || len(events) == 0 {
+		return
+	}
+	if err := api.conversationStore.AppendEvents(conversationID, events); err != nil {
+		log.Printf("Failed to save chat events for %s: %v", conversationID, err)
+	}
+}
+
 // handleNotify POST /api/notify — Bridge(OpenClaw)가 알림 전송
 func (api *APIServer) handleNotify(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -382,20 +698,31 @@ func (api *APIServer) handleNotify(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	sent, fcmSent := api.sendNotification(req.InstanceID, req.Title, req.Body)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sent":    sent,
+		"fcmSent": fcmSent,
+	})
+}
+
+// sendNotification sends via the WebSocket hub, falling back to FCM when no
+// app is connected
+func (api *APIServer) sendNotification(instanceID, title, body string) (sent, fcmSent int) {
 	// Send via WebSocket hub
-	api.notifyHub.SendTo(req.InstanceID, "info", req.Title, req.Body)
-	sent := api.notifyHub.ClientCount()
+	api.notifyHub.SendTo(instanceID, "info", title, body)
+	sent = api.notifyHub.ClientCount()
 
-	log.Printf("[Notify] WebSocket sent (clients=%d, instanceId=%q, title=%q)", sent, req.InstanceID, req.Title)
+	log.Printf("[Notify] WebSocket sent (clients=%d, instanceId=%q, title=%q)", sent, instanceID, title)
 
 	// FCM fallback: if no WebSocket clients received the notification, try FCM push
-	fcmSent := 0
 	if sent == 0 && api.fcmManager != nil {
 		var fcmErr error
-		if req.InstanceID != "" {
-			fcmErr = api.fcmManager.SendPushTo(req.InstanceID, req.Title, req.Body)
+		if instanceID != "" {
+			fcmErr = api.fcmManager.SendPushTo(instanceID, title, body)
 		} else {
-			fcmErr = api.fcmManager.SendPush(req.Title, req.Body)
+			fcmErr = api.fcmManager.SendPush(title, body)
 		}
 		if fcmErr != nil {
 			log.Printf("[Notify] FCM fallback failed: %v", fcmErr)
@@ -404,12 +731,30 @@ func (api *APIServer) handleNotify(w http.ResponseWriter, r *http.Request) {
 			log.Printf("[Notify] FCM fallback sent successfully")
 		}
 	}
+	return sent, fcmSent
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"sent":    sent,
-		"fcmSent": fcmSent,
-	})
+// bridgeNotify delivers a notify message from a bridge to the apps using that
+// bridge, falling back to FCM when none of them is connected
+func (api *APIServer) bridgeNotify(bridge *BridgeConnection, notify NotifyMessage) NotifyResultMessage {
+	result := NotifyResultMessage{Type: MsgTypeNotifyResult, ID: notify.ID}
+	instanceIDs := api.bridgeManager.InstanceAliases(bridge)
+
+	level := notify.Level
+	if level == "" {
+		level = "info"
+	}
+	result.Sent = api.notifyHub.SendToInstances(instanceIDs, level, notify.Title, notify.Body)
+
+	if api.fcmManager != nil && (notify.Push == "always" || (notify.Push == "" && result.Sent == 0)) {
+		var err error
+		result.FCMSent, err = api.fcmManager.SendPushToInstances(instanceIDs, notify.Title, notify.Body)
+		if err != nil {
+			result.Error = err.Error()
+		}
+	}
+	log.Printf("[Notify] From bridge %s (%s): sent=%d fcmSent=%d title=%q", bridge.Name, bridge.ID, result.Sent, result.FCMSent, notify.Title)
+	return result
 }
 
 // handleConversations handles GET /api/conversations (list) and POST /api/conversations (create)
@@ -426,7 +771,26 @@ func (api *APIServer) handleConversations(w http.ResponseWriter, r *http.Request
 
 // handleListConversations handles GET /api/conversations
 func (api *APIServer) handleListConversations(w http.ResponseWriter, r *http.Request) {
-	conversations, err := api.conversationStore.List()
+	q := r.URL.Query()
+	filter := ConversationFilter{
+		Tag:    q.Get("tag"),
+		Folder: q.Get("folder"),
+		Query:  q.Get("q"),
+	}
+	if v := q.Get("pinned"); v != "" {
+		pinned := v == "true" || v == "1"
+		filter.Pinned = &pinned
+	}
+	switch v := q.Get("archived"); v {
+	case "":
+	case "all":
+		filter.AllArchived = true
+	default:
+		archived := v == "true" || v == "1"
+		filter.Archived = &archived
+	}
+
+	conversations, err := api.conversationStore.List(filter)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -454,7 +818,16 @@ func (api *APIServer) handleCreateConversation(w http.ResponseWriter, r *http.Re
 		req.ID = fmt.Sprintf("%d", time.Now().UnixMilli())
 	}
 
+	if err := api.storage.CheckQuota(StorageConversations, 0); err != nil {
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+
 	conversation, err := api.conversationStore.Create(req.ID, req.Title)
+	if errors.Is(err, ErrReservedID) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -484,6 +857,19 @@ func (api *APIServer) handleConversationByID(w http.ResponseWriter, r *http.Requ
 
 	conversationID := parts[0]
 
+	// Trash: GET lists trashed conversations, DELETE empties the trash
+	if conversationID == trashConversationID && len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			api.handleListTrash(w, r)
+		case http.MethodDelete:
+			api.handleEmptyTrash(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
 	// Check if it's a messages endpoint
 	if len(parts) >= 2 && parts[1] == "messages" {
 		switch r.Method {
@@ -497,12 +883,33 @@ func (api *APIServer) handleConversationByID(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// Branching and trash endpoints
+	if len(parts) >= 2 {
+		switch {
+		case parts[1] == "tree" && r.Method == http.MethodGet:
+			api.handleGetTree(w, r, conversationID)
+		case parts[1] == "fork" && r.Method == http.MethodPost:
+			api.handleForkConversation(w, r, conversationID)
+		case parts[1] == "branches" && r.Method == http.MethodGet:
+			api.handleListBranches(w, r, conversationID)
+		case parts[1] == "branches" && r.Method == http.MethodPut:
+			api.handleSwitchBranch(w, r, conversationID)
+		case parts[1] == "restore" && r.Method == http.MethodPost:
+			api.handleRestoreConversation(w, r, conversationID)
+		case parts[1] == "events" && r.Method == http.MethodGet:
+			api.handleGetEvents(w, r, conversationID)
+		default:
+			http.Error(w, "Not found", http.StatusNotFound)
+		}
+		return
+	}
+
 	// Handle conversation-level operations
 	switch r.Method {
 	case http.MethodDelete:
 		api.handleDeleteConversation(w, r, conversationID)
 	case http.MethodPatch:
-		api.handleUpdateTitle(w, r, conversationID)
+		api.handleUpdateConversation(w, r, conversationID)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
@@ -522,16 +929,28 @@ func (api *APIServer) handleGetMessages(w http.ResponseWriter, r *http.Request,
 
 // handleSaveMessages handles PUT /api/conversations/{id}/messages
 func (api *APIServer) handleSaveMessages(w http.ResponseWriter, r *http.Request, conversationID string) {
+	if err := api.storage.CheckQuota(StorageConversations, r.ContentLength); err != nil {
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+
 	var messages []ConversationMessage
-	if err := json.NewDecoder(r.Body).Decode(&messages); err != nil {
+	if err := json.NewDecoder(api.storage.LimitReader(StorageConversations, r.Body)).Decode(&messages); err != nil {
+		if errors.Is(err, ErrQuotaExceeded) {
+			http.Error(w, err.Error(), http.StatusInsufficientStorage)
+			return
+		}
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
 	if err := api.conversationStore.SetMessages(conversationID, messages); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeConversationError(w, err)
 		return
 	}
+	if api.summarizer != nil {
+		api.summarizer.Notify(conversationID)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
@@ -539,9 +958,129 @@ func (api *APIServer) handleSaveMessages(w http.ResponseWriter, r *http.Request,
 	})
 }
 
+// handleGetTree handles GET /api/conversations/{id}/tree
+func (api *APIServer) handleGetTree(w http.ResponseWriter, r *http.Request, conversationID string) {
+	messages, err := api.conversationStore.GetTree(conversationID)
+	if err != nil {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
+
+// handleForkConversation handles POST /api/conversations/{id}/fork
+// Body: {"messageId": "...", "messages": [...]} — new messages are attached
+// below messageId (empty = new root) and the resulting branch becomes active.
+func (api *APIServer) handleForkConversation(w http.ResponseWriter, r *http.Request, conversationID string) {
+	var req struct {
+		MessageID string                `json:"messageId"`
+		Messages  []ConversationMessage `json:"messages"`
+	}
+	if err := json.NewDecoder(api.storage.LimitReader(StorageConversations, r.Body)).Decode(&req); err != nil {
+		if errors.Is(err, ErrQuotaExceeded) {
+			http.Error(w, err.Error(), http.StatusInsufficientStorage)
+			return
+		}
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.MessageID == "" && len(req.Messages) == 0 {
+		http.Error(w, "messageId or messages required", http.StatusBadRequest)
+		return
+	}
+	if err := api.storage.CheckQuota(StorageConversations, r.ContentLength); err != nil {
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+
+	messages, err := api.conversationStore.Fork(conversationID, req.MessageID, req.Messages)
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+	if api.summarizer != nil {
+		api.summarizer.Notify(conversationID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
+
+// handleGetEvents handles GET /api/conversations/{id}/events[?requestId=...]
+func (api *APIServer) handleGetEvents(w http.ResponseWriter, r *http.Request, conversationID string) {
+	events, err := api.conversationStore.GetEvents(conversationID, r.URL.Query().Get("requestId"))
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// handleListBranches handles GET /api/conversations/{id}/branches
+func (api *APIServer) handleListBranches(w http.ResponseWriter, r *http.Request, conversationID string) {
+	branches, err := api.conversationStore.Branches(conversationID)
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(branches)
+}
+
+// handleSwitchBranch handles PUT /api/conversations/{id}/branches
+// Body: {"messageId": "..."} — any message on the wanted branch.
+func (api *APIServer) handleSwitchBranch(w http.ResponseWriter, r *http.Request, conversationID string) {
+	var req struct {
+		MessageID string `json:"messageId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.MessageID == "" {
+		http.Error(w, "messageId is required", http.StatusBadRequest)
+		return
+	}
+
+	messages, err := api.conversationStore.SwitchBranch(conversationID, req.MessageID)
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
+
+// writeConversationError maps conversation store errors to HTTP status codes
+func writeConversationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrConversationNotFound), errors.Is(err, ErrMessageNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 // handleDeleteConversation handles DELETE /api/conversations/{id}
+// Moves the conversation to the trash; ?permanent=true deletes it right away.
 func (api *APIServer) handleDeleteConversation(w http.ResponseWriter, r *http.Request, conversationID string) {
-	if err := api.conversationStore.Delete(conversationID); err != nil {
+	var err error
+	if r.URL.Query().Get("permanent") == "true" {
+		err = api.conversationStore.DeletePermanently(conversationID)
+	} else {
+		err = api.conversationStore.Delete(conversationID)
+	}
+	if errors.Is(err, ErrConversationNotFound) {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
 		http.Error(w, "Failed to delete conversation", http.StatusInternalServerError)
 		return
 	}
@@ -552,29 +1091,75 @@ func (api *APIServer) handleDeleteConversation(w http.ResponseWriter, r *http.Re
 	})
 }
 
-// handleUpdateTitle handles PATCH /api/conversations/{id}
-func (api *APIServer) handleUpdateTitle(w http.ResponseWriter, r *http.Request, conversationID string) {
-	var req struct {
-		Title string `json:"title"`
+// handleRestoreConversation handles POST /api/conversations/{id}/restore
+func (api *APIServer) handleRestoreConversation(w http.ResponseWriter, r *http.Request, conversationID string) {
+	meta, err := api.conversationStore.Restore(conversationID)
+	if err != nil {
+		writeConversationError(w, err)
+		return
 	}
-	
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(meta)
+}
+
+// handleListTrash handles GET /api/conversations/trash
+func (api *APIServer) handleListTrash(w http.ResponseWriter, r *http.Request) {
+	conversations, err := api.conversationStore.ListTrash()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"conversations": conversations,
+		"retentionDays": api.config.TrashRetentionDays,
+	})
+}
+
+// handleEmptyTrash handles DELETE /api/conversations/trash
+func (api *APIServer) handleEmptyTrash(w http.ResponseWriter, r *http.Request) {
+	purged, err := api.conversationStore.PurgeTrash(0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"purged": purged,
+	})
+}
+
+// handleUpdateConversation handles PATCH /api/conversations/{id}
+// Body may contain any of title, tags, folder, pinned, archived.
+func (api *APIServer) handleUpdateConversation(w http.ResponseWriter, r *http.Request, conversationID string) {
+	var req ConversationUpdate
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	if req.Title == "" {
+	if req.Title == nil && req.Tags == nil && req.Folder == nil && req.Pinned == nil && req.Archived == nil {
+		http.Error(w, "Nothing to update", http.StatusBadRequest)
+		return
+	}
+	if req.Title != nil && *req.Title == "" {
 		http.Error(w, "Title is required", http.StatusBadRequest)
 		return
 	}
 
-	if err := api.conversationStore.UpdateTitle(conversationID, req.Title); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	meta, err := api.conversationStore.Update(conversationID, req)
+	if err != nil {
+		writeConversationError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status": "success",
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":       "success",
+		"conversation": meta,
 	})
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -9,12 +10,14 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 )
 
 // APKHandler handles APK distribution endpoints
 type APKHandler struct {
 	dataDir string
+	storage *StorageManager // optional, enforces the APK quota
 }
 
 // NewAPKHandler creates a new APK handler
@@ -26,10 +29,76 @@ func (h *APKHandler) apkDir() string {
 	return filepath.Join(h.dataDir, "apk")
 }
 
+// apkPath is the single-build location used before builds were versioned
 func (h *APKHandler) apkPath() string {
 	return filepath.Join(h.apkDir(), "app-debug.apk")
 }
 
+func (h *APKHandler) buildsDir() string {
+	return filepath.Join(h.apkDir(), "builds")
+}
+
+func (h *APKHandler) buildPath(version string, versionCode int) string {
+	return filepath.Join(h.buildsDir(), fmt.Sprintf("voicechat-%s-%d.apk", version, versionCode))
+}
+
+// currentBuild returns the path of the APK referenced by meta.json,
+// falling back to the legacy single-build file.
+func (h *APKHandler) currentBuild() string {
+	data, err := os.ReadFile(h.metaPath())
+	if err == nil {
+		var meta struct {
+			File string `json:"file"`
+		}
+		if json.Unmarshal(data, &meta) == nil && meta.File != "" {
+			return filepath.Join(h.buildsDir(), filepath.Base(meta.File))
+		}
+	}
+	return h.apkPath()
+}
+
+// PruneBuilds removes all but the newest keep builds. The current build is
+// always kept. Returns the number of builds removed.
+func (h *APKHandler) PruneBuilds(keep int) (int, error) {
+	entries, err := os.ReadDir(h.buildsDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	type build struct {
+		path    string
+		modTime int64
+	}
+	var builds []build
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".apk" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		builds = append(builds, build{filepath.Join(h.buildsDir(), e.Name()), info.ModTime().UnixNano()})
+	}
+	sort.Slice(builds, func(i, j int) bool { return builds[i].modTime > builds[j].modTime })
+
+	current := h.currentBuild()
+	removed := 0
+	for i, b := range builds {
+		if i < keep || b.path == current {
+			continue
+		}
+		if err := os.Remove(b.path); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
 func (h *APKHandler) metaPath() string {
 	return filepath.Join(h.apkDir(), "meta.json")
 }
@@ -59,7 +128,7 @@ func (h *APKHandler) HandleDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	apkFile := h.apkPath()
+	apkFile := h.currentBuild()
 	info, err := os.Stat(apkFile)
 	if err != nil {
 		http.Error(w, "APK not found", http.StatusNotFound)
@@ -107,31 +176,60 @@ func (h *APKHandler) HandleUpload(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Ensure apk directory exists
-	if err := os.MkdirAll(h.apkDir(), 0755); err != nil {
+	if h.storage != nil {
+		if err := h.storage.CheckQuota(StorageAPK, r.ContentLength); err != nil {
+			http.Error(w, err.Error(), http.StatusInsufficientStorage)
+			return
+		}
+	}
+
+	// Ensure builds directory exists
+	if err := os.MkdirAll(h.buildsDir(), 0755); err != nil {
 		http.Error(w, "Failed to create directory", http.StatusInternalServerError)
 		return
 	}
 
-	// Save APK file
-	apkFile, err := os.Create(h.apkPath())
+	// Save APK file to a temp file first so a failed re-upload of the current
+	// version leaves the live build untouched (.tmp is skipped by PruneBuilds)
+	buildFile := h.buildPath(version, versionCode)
+	tmpFile, err := os.CreateTemp(h.buildsDir(), "upload-*.tmp")
 	if err != nil {
 		http.Error(w, "Failed to save APK", http.StatusInternalServerError)
 		return
 	}
-	defer apkFile.Close()
+	defer os.Remove(tmpFile.Name()) // no-op once renamed
 
-	size, err := io.Copy(apkFile, r.Body)
+	var body io.Reader = r.Body
+	if h.storage != nil {
+		body = h.storage.LimitReader(StorageAPK, r.Body)
+	}
+	size, err := io.Copy(tmpFile, body)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
+		if errors.Is(err, ErrQuotaExceeded) {
+			http.Error(w, err.Error(), http.StatusInsufficientStorage)
+			return
+		}
 		http.Error(w, "Failed to write APK", http.StatusInternalServerError)
 		return
 	}
+	if err := os.Chmod(tmpFile.Name(), 0644); err != nil {
+		http.Error(w, "Failed to save APK", http.StatusInternalServerError)
+		return
+	}
+	if err := os.Rename(tmpFile.Name(), buildFile); err != nil {
+		http.Error(w, "Failed to save APK", http.StatusInternalServerError)
+		return
+	}
 
 	// Save metadata
 	meta := map[string]interface{}{
 		"version":     version,
 		"versionCode": versionCode,
 		"size":        size,
+		"file":        filepath.Base(buildFile),
 		"downloadUrl": "/api/apk/download",
 	}
 	metaData, _ := json.MarshalIndent(meta, "", "  ")
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ConversationBranch summarizes one root-to-leaf path of a conversation
+type ConversationBranch struct {
+	LeafID       string `json:"leafId"`
+	ForkID       string `json:"forkId,omitempty"` // first message not shared with an earlier branch
+	MessageCount int    `json:"messageCount"`
+	Preview      string `json:"preview"`
+	UpdatedAt    int64  `json:"updatedAt,omitempty"`
+	Active       bool   `json:"active"`
+}
+
+// messageTree indexes conversation messages by ID and parent
+type messageTree struct {
+	nodes    []ConversationMessage
+	byID     map[string]int
+	children map[string][]string // parentID -> child IDs in insertion order
+}
+
+var messageSeq uint64
+
+// generateMessageID generates a unique ID for conversation messages
+func generateMessageID() string {
+	return fmt.Sprintf("msg_%d_%d", time.Now().UnixNano(), atomic.AddUint64(&messageSeq, 1))
+}
+
+// newMessageTree builds a tree from stored messages. Messages written before
+// branching existed have no IDs; they are given position-based IDs (stable
+// until the file is rewritten) and chained in file order.
+func newMessageTree(msgs []ConversationMessage) *messageTree {
+	t := &messageTree{
+		nodes:    make([]ConversationMessage, 0, len(msgs)),
+		byID:     make(map[string]int),
+		children: make(map[string][]string),
+	}
+
+	prev := ""
+	for i, m := range msgs {
+		if m.ID == "" {
+			m.ID = fmt.Sprintf("msg_legacy_%d", i)
+			m.ParentID = prev
+		}
+		t.insert(m)
+		prev = m.ID
+	}
+	return t
+}
+
+func (t *messageTree) insert(m ConversationMessage) {
+	t.byID[m.ID] = len(t.nodes)
+	t.nodes = append(t.nodes, m)
+	t.children[m.ParentID] = append(t.children[m.ParentID], m.ID)
+}
+
+func (t *messageTree) has(id string) bool {
+	_, ok := t.byID[id]
+	return ok
+}
+
+// add appends msg as a child of parentID, assigning a fresh ID
+func (t *messageTree) add(parentID string, msg ConversationMessage) ConversationMessage {
+	msg.ID = generateMessageID()
+	msg.ParentID = parentID
+	if msg.Timestamp == 0 {
+		msg.Timestamp = time.Now().UnixMilli()
+	}
+	t.insert(msg)
+	return msg
+}
+
+// matchChild finds an existing child of parentID equivalent to msg
+func (t *messageTree) matchChild(parentID string, msg ConversationMessage) string {
+	for _, id := range t.children[parentID] {
+		if msg.ID != "" {
+			if id == msg.ID {
+				return id
+			}
+			continue
+		}
+		n := t.nodes[t.byID[id]]
+		if n.Role == msg.Role && n.Content.Equal(msg.Content) {
+			return id
+		}
+	}
+	return ""
+}
+
+// resolveLeaf returns leafID if it exists, otherwise the last stored message
+func (t *messageTree) resolveLeaf(leafID string) string {
+	if t.has(leafID) {
+		return leafID
+	}
+	if len(t.nodes) == 0 {
+		return ""
+	}
+	return t.nodes[len(t.nodes)-1].ID
+}
+
+// latestLeaf follows the most recently added child from id down to a leaf
+func (t *messageTree) latestLeaf(id string) string {
+	for {
+		kids := t.children[id]
+		if len(kids) == 0 {
+			return id
+		}
+		id = kids[len(kids)-1]
+	}
+}
+
+// path returns the messages from the root down to leafID
+func (t *messageTree) path(leafID string) []ConversationMessage {
+	var rev []ConversationMessage
+	for id := leafID; id != ""; {
+		i, ok := t.byID[id]
+		if !ok {
+			break
+		}
+		rev = append(rev, t.nodes[i])
+		id = t.nodes[i].ParentID
+	}
+
+	path := make([]ConversationMessage, len(rev))
+	for i, m := range rev {
+		path[len(rev)-1-i] = m
+	}
+	return path
+}
+
+// branches lists every leaf of the tree in insertion order
+func (t *messageTree) branches(activeLeaf string) []ConversationBranch {
+	branches := []ConversationBranch{}
+	seen := make(map[string]bool)
+
+	for _, n := range t.nodes {
+		if len(t.children[n.ID]) > 0 {
+			continue
+		}
+		path := t.path(n.ID)
+		b := ConversationBranch{
+			LeafID:       n.ID,
+			MessageCount: len(path),
+			UpdatedAt:    n.Timestamp,
+			Active:       n.ID == activeLeaf,
+		}
+		for _, m := range path {
+			if !seen[m.ID] && b.ForkID == "" {
+				b.ForkID = m.ID
+			}
+			seen[m.ID] = true
+		}
+		for i := len(path) - 1; i >= 0; i-- {
+			if path[i].Role == "user" {
+				b.Preview = path[i].Content.String()
+				break
+			}
+		}
+		if len([]rune(b.Preview)) > 50 {
+			b.Preview = string([]rune(b.Preview)[:50]) + "…"
+		}
+		branches = append(branches, b)
+	}
+	return branches
+}
@@ -3,55 +3,302 @@ package main
 import (
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// RequestChannels holds per-request channels to avoid fan-out bugs
-type RequestChannels struct {
-	ResponseCh chan ChatResponseMessage
-	ErrorCh    chan ChatErrorMessage
-	FileCh     chan FileResponseMessage
-}
-
 // BridgeConnection represents a connected bridge client
 type BridgeConnection struct {
 	ID          string    `json:"id"`
 	Name        string    `json:"name"`
-	Status      string    `json:"status"`
+	Status      string    `json:"status"` // guarded by stateMu
 	ConnectedAt time.Time `json:"connectedAt"`
-	Conn        net.Conn  `json:"-"`
-	LastPing    time.Time `json:"-"`
-	// Per-request channel registry (replaces shared channels)
-	requestChans map[string]*RequestChannels `json:"-"`
-	requestMu    sync.RWMutex               `json:"-"`
+	LastPing    time.Time `json:"-"` // guarded by stateMu
+	Group       string    `json:"group,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	// Capabilities declared at registration, e.g. "waker"
+	Capabilities []string `json:"capabilities,omitempty"`
+	MAC          string   `json:"-"`
+	// Metadata reported at registration
+	Version string
+	OS      string
+	health  bridgeHealth
+	stateMu sync.RWMutex // Status and LastPing
+	// Pending RPCs by ID
+	rpcs   map[string]chan RPCResponseMessage
+	rpcsMu sync.Mutex
+	// Models and agents, updated by bridge_info
+	models, agents []string
+	infoMu         sync.RWMutex
+	// Per-request stream registry (replaces shared channels)
+	requestChans map[string]*RequestStream `json:"-"`
+	requestMu    sync.RWMutex              `json:"-"`
+	inFlight     int32                     // requests currently registered
+	// Outbound frames, written by bridgeWriter; control frames go first
+	control   chan outboundFrame
+	payload   chan outboundFrame
+	closed    chan struct{}
+	closeOnce sync.Once
+	link      bridgeLink // TCP or WebSocket
+	// Binary streams (CapabilityBinaryFrames or WebSocket)
+	inStreams  map[uint32]*inStream // open inbound file streams, reader only
+	nextStream uint32               // last outbound stream ID
+	// Deflate (CapabilityDeflate): outbound frames of at least compressMin bytes are compressed
+	deflate     bool
+	compressMin int
+	// Link byte counters: frame payloads before and after compression
+	bytesOut, wireOut int64
+	bytesIn, wireIn   int64
+}
+
+// LinkStats reports the bytes a bridge link carried and what compression saved
+type LinkStats struct {
+	Compression  string `json:"compression,omitempty"`
+	Transport    string `json:"transport"`    // "tcp" or "websocket"
+	BytesOut     int64  `json:"bytesOut"`     // frame payloads sent, before compression
+	WireBytesOut int64  `json:"wireBytesOut"` // after compression
+	BytesIn      int64  `json:"bytesIn"`      // frame payloads received, after decompression
+	WireBytesIn  int64  `json:"wireBytesIn"`
+	SavedBytes   int64  `json:"savedBytes"`
+}
+
+// Stats returns the link's byte counters
+func (bc *BridgeConnection) Stats() LinkStats {
+	s := LinkStats{
+		BytesOut:     atomic.LoadInt64(&bc.bytesOut),
+		WireBytesOut: atomic.LoadInt64(&bc.wireOut),
+		BytesIn:      atomic.LoadInt64(&bc.bytesIn),
+		WireBytesIn:  atomic.LoadInt64(&bc.wireIn),
+	}
+	if bc.deflate {
+		s.Compression = "deflate"
+	}
+	s.Transport = "tcp"
+	if _, ok := bc.link.(*wsLink); ok {
+		s.Transport = "websocket"
+	}
+	s.SavedBytes = s.BytesOut - s.WireBytesOut + s.BytesIn - s.WireBytesIn
+	return s
+}
+
+// outboundFrame is a message waiting for the bridge writer: a JSON message
+// or, if binary is set, a binary chunk payload. result, if set, receives the
+// outcome of the write.
+type outboundFrame struct {
+	msg    interface{}
+	binary []byte
+	result chan error
+}
+
+var ErrBridgeClosed = errors.New("bridge connection closed")
+
+// newBridgeConnection wraps a registered connection
+func newBridgeConnection(link bridgeLink, reg RegisterMessage) *BridgeConnection {
+	return &BridgeConnection{
+		ID:           generateID(),
+		Name:         reg.Name,
+		Status:       "online",
+		ConnectedAt:  time.Now(),
+		LastPing:     time.Now(),
+		Group:        strings.TrimSpace(reg.Group),
+		Tags:         normalizeTags(reg.Tags),
+		Capabilities: normalizeTags(reg.Capabilities),
+		MAC:          reg.MAC,
+		Version:      reg.Version,
+		OS:           reg.OS,
+		models:       normalizeTags(reg.Models),
+		agents:       normalizeTags(reg.Agents),
+		requestChans: make(map[string]*RequestStream),
+		control:      make(chan outboundFrame, 64),
+		payload:      make(chan outboundFrame, 64),
+		closed:       make(chan struct{}),
+		link:         link,
+		inStreams:    make(map[uint32]*inStream),
+		rpcs:         make(map[string]chan RPCResponseMessage),
+	}
+}
+
+// Send queues a payload message (chat or wake request) behind any pending
+// control frames and waits until it has been written
+func (bc *BridgeConnection) Send(msg interface{}) error {
+	return bc.sendPayload(outboundFrame{msg: msg})
+}
+
+// SendBinary queues a binary chunk of an outbound stream like Send. Only for
+// bridges using binary frames.
+func (bc *BridgeConnection) SendBinary(streamID uint32, end bool, data []byte) error {
+	return bc.sendPayload(outboundFrame{binary: EncodeBinaryChunk(streamID, end, data)})
 }
 
-// RegisterRequest creates per-request channels
-func (bc *BridgeConnection) RegisterRequest(requestID string) *RequestChannels {
-	ch := &RequestChannels{
-		ResponseCh: make(chan ChatResponseMessage, 50),
-		ErrorCh:    make(chan ChatErrorMessage, 10),
-		FileCh:     make(chan FileResponseMessage, 10),
+func (bc *BridgeConnection) sendPayload(frame outboundFrame) error {
+	result := make(chan error, 1)
+	frame.result = result
+	select {
+	case bc.payload <- frame:
+	case <-bc.closed:
+		return ErrBridgeClosed
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-bc.closed:
+		return ErrBridgeClosed
+	}
+}
+
+// SendControl queues a control message (heartbeat, credit, cancel, file
+// stored) ahead of payloads without waiting for the write
+func (bc *BridgeConnection) SendControl(msg interface{}) error {
+	select {
+	case bc.control <- outboundFrame{msg: msg}:
+		return nil
+	case <-bc.closed:
+		return ErrBridgeClosed
+	}
+}
+
+// writeFrame writes one outbound frame in the connection's framing
+func (bc *BridgeConnection) writeFrame(frame outboundFrame) error {
+	frameType, data := FrameBinary, frame.binary
+	if data == nil {
+		var err error
+		if data, err = json.Marshal(frame.msg); err != nil {
+			return err
+		}
+		frameType = FrameJSON
 	}
+	atomic.AddInt64(&bc.bytesOut, int64(len(data)))
+	if bc.deflate && len(data) >= bc.compressMin {
+		// Frames that do not shrink (e.g. already compressed files) go out as they are
+		if compressed := DeflatePayload(data); len(compressed) < len(data) {
+			frameType, data = frameType|FrameCompressed, compressed
+		}
+	}
+	atomic.AddInt64(&bc.wireOut, int64(len(data)))
+	return bc.link.WriteFrame(frameType, data)
+}
+
+// readFrame reads the next JSON message. Binary chunks are passed to onBinary.
+func (bc *BridgeConnection) readFrame(maxSize int, onBinary func([]byte)) ([]byte, error) {
+	for {
+		frameType, payload, err := bc.link.ReadFrame(maxSize)
+		if err != nil {
+			return nil, err
+		}
+		atomic.AddInt64(&bc.wireIn, int64(len(payload)))
+		if frameType&FrameCompressed != 0 {
+			if !bc.deflate {
+				return nil, fmt.Errorf("compressed frame without negotiated compression")
+			}
+			if payload, err = InflatePayload(payload, maxSize); err != nil {
+				return nil, fmt.Errorf("inflate frame: %w", err)
+			}
+			frameType &^= FrameCompressed
+		}
+		atomic.AddInt64(&bc.bytesIn, int64(len(payload)))
+		switch frameType {
+		case FrameJSON:
+			return payload, nil
+		case FrameBinary:
+			onBinary(payload)
+		default:
+			log.Printf("Ignoring frame of unknown type 0x%02x from bridge %s", frameType, bc.ID)
+		}
+	}
+}
+
+// Close shuts the connection down; the reader and writer then exit
+func (bc *BridgeConnection) Close() {
+	bc.closeOnce.Do(func() {
+		close(bc.closed)
+		bc.link.Close()
+	})
+}
+
+// InFlight returns the number of chat requests the bridge is serving
+func (bc *BridgeConnection) InFlight() int {
+	return int(atomic.LoadInt32(&bc.inFlight))
+}
+
+// Health returns the bridge's health report
+func (bc *BridgeConnection) Health() HealthInfo {
+	_, lastPing := bc.state()
+	return bc.health.report(lastPing)
+}
+
+// state returns the bridge's status and the time of its last heartbeat
+func (bc *BridgeConnection) state() (string, time.Time) {
+	bc.stateMu.RLock()
+	defer bc.stateMu.RUnlock()
+	return bc.Status, bc.LastPing
+}
+
+// heartbeat records a heartbeat from the bridge
+func (bc *BridgeConnection) heartbeat() {
+	bc.stateMu.Lock()
+	bc.LastPing = time.Now()
+	bc.stateMu.Unlock()
+}
+
+// setStatus changes the bridge's status
+func (bc *BridgeConnection) setStatus(status string) {
+	bc.stateMu.Lock()
+	bc.Status = status
+	bc.stateMu.Unlock()
+}
+
+// Catalog returns the models and agents the bridge offers
+func (bc *BridgeConnection) Catalog() (models, agents []string) {
+	bc.infoMu.RLock()
+	defer bc.infoMu.RUnlock()
+	return bc.models, bc.agents
+}
+
+// Offers reports whether the bridge can serve agent and model. Empty values
+// mean the bridge's default; a bridge that lists nothing accepts any.
+func (bc *BridgeConnection) Offers(agent, model string) bool {
+	models, agents := bc.Catalog()
+	return (agent == "" || len(agents) == 0 || containsString(agents, agent)) &&
+		(model == "" || len(models) == 0 || containsString(models, model))
+}
+
+// degraded reports whether any health threshold is crossed
+func (bc *BridgeConnection) degraded() bool {
+	return len(bc.Health().Degraded) > 0
+}
+
+// inGroup reports whether the bridge declared group as its group or a tag
+func (bc *BridgeConnection) inGroup(group string) bool {
+	return bc.Group == group || containsString(bc.Tags, group)
+}
+
+// RegisterRequest creates a per-request stream buffering up to limit bytes
+func (bc *BridgeConnection) RegisterRequest(requestID string, limit int) *RequestStream {
+	stream := NewRequestStream(limit)
 	bc.requestMu.Lock()
-	bc.requestChans[requestID] = ch
+	bc.requestChans[requestID] = stream
 	bc.requestMu.Unlock()
-	return ch
+	atomic.AddInt32(&bc.inFlight, 1)
+	return stream
 }
 
-// UnregisterRequest removes per-request channels
+// UnregisterRequest removes a per-request stream
 func (bc *BridgeConnection) UnregisterRequest(requestID string) {
 	bc.requestMu.Lock()
 	delete(bc.requestChans, requestID)
 	bc.requestMu.Unlock()
+	atomic.AddInt32(&bc.inFlight, -1)
 }
 
-// GetRequestChannels returns channels for a specific request
-func (bc *BridgeConnection) GetRequestChannels(requestID string) *RequestChannels {
+// GetRequestStream returns the stream of a specific request
+func (bc *BridgeConnection) GetRequestStream(requestID string) *RequestStream {
 	bc.requestMu.RLock()
 	defer bc.requestMu.RUnlock()
 	return bc.requestChans[requestID]
@@ -62,6 +309,18 @@ type BridgeManager struct {
 	connections map[string]*BridgeConnection
 	mutex       sync.RWMutex
 	config      *Config
+	fileStore   *FileStore // receives file_chunk uploads, optional
+	onRegister  func(*BridgeConnection)
+	onNotify    func(*BridgeConnection, NotifyMessage) NotifyResultMessage
+	guard       *BridgeGuard
+	// Names of disconnected bridges by ID, so requests for a bridge that is
+	// gone can wait for it to reconnect under a new ID
+	departed map[string]departedBridge
+}
+
+type departedBridge struct {
+	name string
+	at   time.Time
 }
 
 // NewBridgeManager creates a new bridge manager
@@ -69,9 +328,26 @@ func NewBridgeManager(config *Config) *BridgeManager {
 	return &BridgeManager{
 		connections: make(map[string]*BridgeConnection),
 		config:      config,
+		guard:       NewBridgeGuard(config),
+		departed:    make(map[string]departedBridge),
 	}
 }
 
+// maxRegisterFrame bounds the register frame, the only one read before authentication
+const maxRegisterFrame = 16 << 10
+
+// Limits on inbound binary streams per bridge connection
+const (
+	maxInStreams = 16              // open at once; further stream_open is refused
+	inStreamIdle = 5 * time.Minute // a stream without chunks for this long is dropped
+)
+
+// inStream is an inbound file stream announced with stream_open
+type inStream struct {
+	chunk FileChunkMessage // metadata; Data and Done are set per chunk
+	last  time.Time        // open or last chunk
+}
+
 // StartTCPServer starts the TCP server for bridge connections
 func (bm *BridgeManager) StartTCPServer() error {
 	addr := fmt.Sprintf(":%d", bm.config.BridgePort)
@@ -118,41 +394,73 @@ func (bm *BridgeManager) StartTCPServer() error {
 func (bm *BridgeManager) handleBridgeConnection(conn net.Conn) {
 	defer conn.Close()
 
-	log.Printf("New bridge connection from %s", conn.RemoteAddr())
+	ip := remoteIP(conn)
+	if ok, reason := bm.guard.Admit(ip); !ok {
+		log.Printf("Rejected bridge connection from %s: %s", conn.RemoteAddr(), reason)
+		return
+	}
+	defer bm.guard.Release(ip)
+
+	bm.serveBridge(&tcpLink{conn: conn}, ip)
+}
+
+// serveBridge registers a bridge on a new link and serves it until it goes away
+func (bm *BridgeManager) serveBridge(link bridgeLink, ip string) {
+	log.Printf("New bridge connection from %s", link.RemoteAddr())
 
-	// Wait for register message
-	data, err := ReadMessage(conn)
+	// Wait for register message; unauthenticated peers get a short deadline
+	// and a small frame limit
+	link.SetReadDeadline(time.Now().Add(time.Duration(bm.config.BridgeRegisterTimeoutSeconds) * time.Second))
+	frameType, data, err := link.ReadFrame(maxRegisterFrame)
+	if err == nil && frameType != FrameJSON {
+		err = fmt.Errorf("unexpected frame type 0x%02x", frameType)
+	}
 	if err != nil {
 		log.Printf("Failed to read register message: %v", err)
+		bm.guard.AuthFailed(ip)
 		return
 	}
 
 	var regMsg RegisterMessage
 	if err := json.Unmarshal(data, &regMsg); err != nil {
 		log.Printf("Failed to unmarshal register message: %v", err)
+		bm.guard.AuthFailed(ip)
 		return
 	}
 
 	if regMsg.Type != MsgTypeRegister {
 		log.Printf("Expected register message, got: %s", regMsg.Type)
+		bm.guard.AuthFailed(ip)
 		return
 	}
 
 	// Validate bridge token
 	if err := ValidateBridgeToken(bm.config, regMsg.Token); err != nil {
 		log.Printf("Bridge authentication failed: %v", err)
+		bm.guard.AuthFailed(ip)
 		return
 	}
+	bm.guard.AuthSucceeded(ip)
+	link.SetReadDeadline(time.Time{})
 
 	// Create bridge connection
-	bridge := &BridgeConnection{
-		ID:           generateID(),
-		Name:         regMsg.Name,
-		Status:       "online",
-		ConnectedAt:  time.Now(),
-		Conn:         conn,
-		LastPing:     time.Now(),
-		requestChans: make(map[string]*RequestChannels),
+	bridge := newBridgeConnection(link, regMsg)
+	if link.Typed() || containsString(bridge.Capabilities, CapabilityBinaryFrames) {
+		registered := RegisteredMessage{Type: MsgTypeRegistered, ID: bridge.ID, Framing: "typed"}
+		if bm.config.BridgeCompression && link.CanDeflate() && containsString(bridge.Capabilities, CapabilityDeflate) {
+			bridge.deflate = true
+			bridge.compressMin = bm.config.BridgeCompressMinBytes
+			registered.Compression = "deflate"
+		}
+		// Confirm in the current framing; a TCP bridge switches to typed frames after it
+		data, _ := json.Marshal(registered)
+		link.SetWriteDeadline(time.Now().Add(time.Duration(bm.config.BridgeRegisterTimeoutSeconds) * time.Second))
+		if err := link.WriteFrame(FrameJSON, data); err != nil {
+			log.Printf("Failed to confirm registration of %s: %v", bridge.Name, err)
+			return
+		}
+		link.SetWriteDeadline(time.Time{})
+		link.SetTyped()
 	}
 
 	// Register the bridge
@@ -160,29 +468,33 @@ func (bm *BridgeManager) handleBridgeConnection(conn net.Conn) {
 	bm.connections[bridge.ID] = bridge
 	bm.mutex.Unlock()
 
-	log.Printf("Bridge registered: %s (%s)", bridge.Name, bridge.ID)
-
-	// Handle messages in separate goroutines
-	go bm.bridgeMessageHandler(bridge)
-	go bm.bridgeResponseHandler(bridge)
+	log.Printf("Bridge registered: %s (%s) group=%q tags=%v", bridge.Name, bridge.ID, bridge.Group, bridge.Tags)
+	if bm.onRegister != nil {
+		go bm.onRegister(bridge)
+	}
 
-	// Keep connection alive
-	select {}
+	// Write from a separate goroutine; reading runs here until the bridge goes away
+	go bm.bridgeWriter(bridge)
+	bm.probe(bridge)
+	bm.bridgeMessageHandler(bridge)
 }
 
 // bridgeMessageHandler handles incoming messages from bridge
 func (bm *BridgeManager) bridgeMessageHandler(bridge *BridgeConnection) {
 	defer func() {
 		bm.removeBridge(bridge.ID)
-		bridge.Conn.Close()
+		bridge.Close()
 	}()
 
 	for {
-		data, err := ReadMessage(bridge.Conn)
+		data, err := bridge.readFrame(bm.config.BridgeMaxFrameKB*1024, func(payload []byte) {
+			bm.handleBinaryChunk(bridge, payload)
+		})
 		if err != nil {
 			log.Printf("Failed to read message from bridge %s: %v", bridge.ID, err)
 			return
 		}
+		bridge.health.touch()
 
 		var baseMsg Message
 		if err := json.Unmarshal(data, &baseMsg); err != nil {
@@ -192,9 +504,16 @@ func (bm *BridgeManager) bridgeMessageHandler(bridge *BridgeConnection) {
 
 		switch baseMsg.Type {
 		case MsgTypeHeartbeat:
-			bridge.LastPing = time.Now()
+			bridge.heartbeat()
+			bm.expireInStreams(bridge)
+			var hb HeartbeatMessage
+			if err := json.Unmarshal(data, &hb); err == nil && hb.Pong != 0 {
+				// Answer to our probe, nothing to send back
+				bridge.health.pong(hb.Pong)
+				continue
+			}
 			// Send heartbeat response so bridge's ReadDeadline doesn't expire
-			SendMessage(bridge.Conn, HeartbeatMessage{Type: MsgTypeHeartbeat})
+			bridge.SendControl(HeartbeatMessage{Type: MsgTypeHeartbeat})
 
 		case MsgTypeChatResponse:
 			var respMsg ChatResponseMessage
@@ -202,13 +521,7 @@ func (bm *BridgeManager) bridgeMessageHandler(bridge *BridgeConnection) {
 				log.Printf("Failed to unmarshal chat response: %v", err)
 				continue
 			}
-			if ch := bridge.GetRequestChannels(respMsg.RequestID); ch != nil {
-				select {
-				case ch.ResponseCh <- respMsg:
-				default:
-					log.Printf("Response channel full for request %s", respMsg.RequestID)
-				}
-			}
+			bm.pushToRequest(bridge, respMsg.RequestID, respMsg, len(data))
 
 		case MsgTypeChatError:
 			var errMsg ChatErrorMessage
@@ -216,12 +529,7 @@ func (bm *BridgeManager) bridgeMessageHandler(bridge *BridgeConnection) {
 				log.Printf("Failed to unmarshal chat error: %v", err)
 				continue
 			}
-			if ch := bridge.GetRequestChannels(errMsg.RequestID); ch != nil {
-				select {
-				case ch.ErrorCh <- errMsg:
-				default:
-				}
-			}
+			bm.pushToRequest(bridge, errMsg.RequestID, errMsg, len(data))
 
 		case MsgTypeFileResponse:
 			var fileMsg FileResponseMessage
@@ -229,37 +537,318 @@ func (bm *BridgeManager) bridgeMessageHandler(bridge *BridgeConnection) {
 				log.Printf("Failed to unmarshal file response: %v", err)
 				continue
 			}
-			if ch := bridge.GetRequestChannels(fileMsg.RequestID); ch != nil {
-				select {
-				case ch.FileCh <- fileMsg:
-				default:
-				}
+			bm.pushToRequest(bridge, fileMsg.RequestID, fileMsg, len(data))
+
+		case MsgTypeChatEvent:
+			var eventMsg ChatEventMessage
+			if err := json.Unmarshal(data, &eventMsg); err != nil {
+				log.Printf("Failed to unmarshal chat event: %v", err)
+				continue
+			}
+			if err := eventMsg.Validate(); err != nil {
+				log.Printf("Invalid chat event from bridge %s: %v", bridge.ID, err)
+				continue
+			}
+			if eventMsg.Timestamp == 0 {
+				eventMsg.Timestamp = time.Now().UnixMilli()
+			}
+			bm.pushToRequest(bridge, eventMsg.RequestID, eventMsg, len(data))
+
+		case MsgTypeWakeResult:
+			var result WakeResultMessage
+			if err := json.Unmarshal(data, &result); err != nil {
+				log.Printf("Failed to unmarshal wake result: %v", err)
+				continue
+			}
+			if result.OK {
+				log.Printf("[Wake] %s sent magic packet (%s)", bridge.Name, result.RequestID)
+			} else {
+				log.Printf("[Wake] %s failed to send magic packet (%s): %s", bridge.Name, result.RequestID, result.Error)
 			}
 
+		case MsgTypeBridgeInfo:
+			var info BridgeInfoMessage
+			if err := json.Unmarshal(data, &info); err != nil {
+				log.Printf("Failed to unmarshal bridge info: %v", err)
+				continue
+			}
+			bridge.infoMu.Lock()
+			bridge.models, bridge.agents = normalizeTags(info.Models), normalizeTags(info.Agents)
+			bridge.infoMu.Unlock()
+			log.Printf("Bridge %s (%s) now offers models=%v agents=%v", bridge.Name, bridge.ID, info.Models, info.Agents)
+
+		case MsgTypeRPCResponse:
+			var resp RPCResponseMessage
+			if err := json.Unmarshal(data, &resp); err != nil {
+				log.Printf("Failed to unmarshal rpc response: %v", err)
+				continue
+			}
+			bridge.resolveRPC(resp)
+
+		case MsgTypeNotify:
+			var notify NotifyMessage
+			if err := json.Unmarshal(data, &notify); err != nil {
+				log.Printf("Failed to unmarshal notify: %v", err)
+				continue
+			}
+			go bm.handleNotify(bridge, notify)
+
+		case MsgTypeStreamOpen:
+			var open StreamOpenMessage
+			if err := json.Unmarshal(data, &open); err != nil {
+				log.Printf("Failed to unmarshal stream open: %v", err)
+				continue
+			}
+			if open.Kind != StreamKindFile || open.FileID == "" {
+				log.Printf("Unsupported stream from bridge %s: kind=%q fileId=%q", bridge.ID, open.Kind, open.FileID)
+				continue
+			}
+			bm.expireInStreams(bridge)
+			if _, reopened := bridge.inStreams[open.StreamID]; !reopened && len(bridge.inStreams) >= maxInStreams {
+				log.Printf("Bridge %s has %d open streams, refusing stream %d", bridge.ID, len(bridge.inStreams), open.StreamID)
+				bridge.SendControl(FileStoredMessage{Type: MsgTypeFileStored, FileID: open.FileID, Error: fmt.Sprintf("too many open streams (max %d)", maxInStreams)})
+				continue
+			}
+			bridge.inStreams[open.StreamID] = &inStream{
+				chunk: FileChunkMessage{
+					Type:      MsgTypeFileChunk,
+					RequestID: open.RequestID,
+					FileID:    open.FileID,
+					Filename:  open.Filename,
+					MimeType:  open.MimeType,
+				},
+				last: time.Now(),
+			}
+
+		case MsgTypeFileChunk:
+			var chunk FileChunkMessage
+			if err := json.Unmarshal(data, &chunk); err != nil {
+				log.Printf("Failed to unmarshal file chunk: %v", err)
+				continue
+			}
+			bm.handleFileChunk(bridge, chunk)
+
 		default:
 			log.Printf("Unknown message type from bridge %s: %s", bridge.ID, baseMsg.Type)
 		}
 	}
 }
 
-// bridgeResponseHandler handles responses to be sent to bridge
-func (bm *BridgeManager) bridgeResponseHandler(bridge *BridgeConnection) {
-	// This will be used by the relay system to send chat requests
+// handleNotify delivers a bridge's notification and answers it if asked to
+func (bm *BridgeManager) handleNotify(bridge *BridgeConnection, notify NotifyMessage) {
+	result := NotifyResultMessage{Type: MsgTypeNotifyResult, ID: notify.ID}
+	switch {
+	case notify.Title == "" && notify.Body == "":
+		result.Error = "title or body required"
+	case bm.onNotify == nil:
+		result.Error = "notifications unavailable"
+	default:
+		result = bm.onNotify(bridge, notify)
+	}
+	if notify.ID != "" {
+		bridge.SendControl(result)
+	}
+}
+
+// InstanceAliases returns the instance IDs an app may use to reach bridge:
+// its ID, name, groups and "default" if the bridge serves it
+func (bm *BridgeManager) InstanceAliases(bridge *BridgeConnection) []string {
+	aliases := []string{bridge.ID, bridge.Name}
+	if bridge.Group != "" {
+		aliases = append(aliases, "group:"+bridge.Group)
+	}
+	for _, tag := range bridge.Tags {
+		aliases = append(aliases, "group:"+tag)
+	}
+
+	def := bm.config.DefaultInstance
+	group, isGroup := strings.CutPrefix(def, "group:")
+	if def == "" || def == "default" || def == bridge.ID || def == bridge.Name || (isGroup && bridge.inGroup(group)) {
+		aliases = append(aliases, "default")
+	}
+	return aliases
+}
+
+// pushToRequest buffers a message for the request it belongs to. Messages
+// for unknown (finished or cancelled) requests are dropped.
+func (bm *BridgeManager) pushToRequest(bridge *BridgeConnection, requestID string, msg interface{}, size int) {
+	stream := bridge.GetRequestStream(requestID)
+	if stream == nil {
+		return
+	}
+	if !stream.Push(msg, size) {
+		log.Printf("Request %s on bridge %s exceeded its buffer, cancelling", requestID, bridge.ID)
+		bm.CancelChatRequest(bridge, requestID, "response buffer overflow")
+	}
+}
+
+// handleBinaryChunk appends a binary chunk to the file stream it belongs to,
+// handled like a file_chunk upload
+func (bm *BridgeManager) handleBinaryChunk(bridge *BridgeConnection, payload []byte) {
+	streamID, end, data, err := DecodeBinaryChunk(payload)
+	if err != nil {
+		log.Printf("Bad binary frame from bridge %s: %v", bridge.ID, err)
+		return
+	}
+	stream, ok := bridge.inStreams[streamID]
+	if !ok {
+		log.Printf("Binary frame for unknown stream %d from bridge %s", streamID, bridge.ID)
+		return
+	}
+	stream.last = time.Now()
+	if end {
+		delete(bridge.inStreams, streamID)
+	}
+	chunk := stream.chunk
+	chunk.Data = data
+	chunk.Done = end
+	bm.handleFileChunk(bridge, chunk)
+}
+
+// expireInStreams drops inbound streams idle for longer than inStreamIdle
+// and discards their partial uploads. Called from the bridge's reader.
+func (bm *BridgeManager) expireInStreams(bridge *BridgeConnection) {
+	for id, stream := range bridge.inStreams {
+		if time.Since(stream.last) < inStreamIdle {
+			continue
+		}
+		log.Printf("Stream %d from bridge %s idle for %s, dropping", id, bridge.ID, inStreamIdle)
+		delete(bridge.inStreams, id)
+		if bm.fileStore != nil {
+			bm.fileStore.AbortUpload(bridge.ID + "/" + stream.chunk.FileID)
+		}
+		bridge.SendControl(FileStoredMessage{Type: MsgTypeFileStored, FileID: stream.chunk.FileID, Error: "stream idle timeout"})
+	}
+}
+
+// handleFileChunk stores pushed file bytes and, once complete, forwards the
+// stored file to the request it belongs to
+func (bm *BridgeManager) handleFileChunk(bridge *BridgeConnection, chunk FileChunkMessage) {
+	if bm.fileStore == nil || chunk.FileID == "" {
+		bridge.SendControl(FileStoredMessage{Type: MsgTypeFileStored, FileID: chunk.FileID, Error: "file storage unavailable"})
+		return
+	}
+
+	key := bridge.ID + "/" + chunk.FileID
+	if len(chunk.Data) > 0 {
+		if err := bm.fileStore.AppendChunk(key, chunk.Data); err != nil {
+			log.Printf("File chunk from bridge %s rejected: %v", bridge.ID, err)
+			bridge.SendControl(FileStoredMessage{Type: MsgTypeFileStored, FileID: chunk.FileID, Error: err.Error()})
+			return
+		}
+	}
+	if !chunk.Done {
+		return
+	}
+
+	stored, err := bm.fileStore.FinishUpload(key, chunk.Filename, chunk.MimeType, bridge.ID, chunk.RequestID)
+	if err != nil {
+		log.Printf("Failed to store file from bridge %s: %v", bridge.ID, err)
+		bridge.SendControl(FileStoredMessage{Type: MsgTypeFileStored, FileID: chunk.FileID, Error: err.Error()})
+		return
+	}
+	bridge.SendControl(FileStoredMessage{
+		Type:     MsgTypeFileStored,
+		FileID:   chunk.FileID,
+		URL:      stored.URL(),
+		Size:     stored.Size,
+		MimeType: stored.MimeType,
+	})
+
+	if chunk.RequestID == "" {
+		return
+	}
+	bm.pushToRequest(bridge, chunk.RequestID, FileResponseMessage{
+		Type:      MsgTypeFileResponse,
+		RequestID: chunk.RequestID,
+		Filename:  stored.Name,
+		URL:       stored.URL(),
+		Size:      stored.Size,
+		MimeType:  stored.MimeType,
+		stored:    true,
+	}, 0)
+}
+
+// bridgeWriter is the only writer of a bridge's connection. Control frames
+// are always written before queued payloads. A write that misses its
+// deadline or fails closes the connection.
+func (bm *BridgeManager) bridgeWriter(bridge *BridgeConnection) {
+	timeout := time.Duration(bm.config.BridgeWriteTimeoutSeconds) * time.Second
+	for {
+		var frame outboundFrame
+		select {
+		case frame = <-bridge.control:
+		default:
+			select {
+			case frame = <-bridge.control:
+			case frame = <-bridge.payload:
+			case <-bridge.closed:
+				return
+			}
+		}
+
+		if timeout > 0 {
+			bridge.link.SetWriteDeadline(time.Now().Add(timeout))
+		}
+		err := bridge.writeFrame(frame)
+		if frame.result != nil {
+			frame.result <- err
+		}
+		if err != nil {
+			log.Printf("Failed to write to bridge %s: %v", bridge.ID, err)
+			bridge.Close()
+			return
+		}
+	}
+}
+
+// InstanceInfo is the public view of a bridge returned by /api/instances
+type InstanceInfo struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Status      string    `json:"status"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	Group       string    `json:"group,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	InFlight    int       `json:"inFlight"`
+	// Capabilities declared at registration, e.g. "waker"
+	Capabilities []string   `json:"capabilities,omitempty"`
+	Version      string     `json:"version,omitempty"`
+	OS           string     `json:"os,omitempty"`
+	Models       []string   `json:"models,omitempty"`
+	Agents       []string   `json:"agents,omitempty"`
+	Health       HealthInfo `json:"health"`
+	Link         LinkStats  `json:"link"`
 }
 
 // GetInstances returns all connected instances
-func (bm *BridgeManager) GetInstances() []BridgeConnection {
+func (bm *BridgeManager) GetInstances() []InstanceInfo {
 	bm.mutex.RLock()
 	defer bm.mutex.RUnlock()
 
-	instances := make([]BridgeConnection, 0, len(bm.connections))
+	instances := make([]InstanceInfo, 0, len(bm.connections))
 	for _, bridge := range bm.connections {
-		// Create a copy without the connection and channels
-		instance := BridgeConnection{
-			ID:          bridge.ID,
-			Name:        bridge.Name,
-			Status:      bridge.Status,
-			ConnectedAt: bridge.ConnectedAt,
+		// Copy the public fields only (no connection, channels or locks)
+		models, agents := bridge.Catalog()
+		status, _ := bridge.state()
+		instance := InstanceInfo{
+			ID:           bridge.ID,
+			Name:         bridge.Name,
+			Status:       status,
+			ConnectedAt:  bridge.ConnectedAt,
+			Group:        bridge.Group,
+			Tags:         bridge.Tags,
+			InFlight:     bridge.InFlight(),
+			Capabilities: bridge.Capabilities,
+			Version:      bridge.Version,
+			OS:           bridge.OS,
+			Models:       models,
+			Agents:       agents,
+			Health:       bridge.Health(),
+			Link:         bridge.Stats(),
+		}
+		if instance.Status == "online" && len(instance.Health.Degraded) > 0 {
+			instance.Status = "degraded"
 		}
 		instances = append(instances, instance)
 	}
@@ -274,6 +863,80 @@ func (bm *BridgeManager) GetBridge(id string) *BridgeConnection {
 	return bm.connections[id]
 }
 
+// Candidates resolves a chat target to the bridges that may serve it, least
+// busy first. target is a bridge ID, a bridge name, "group:<name>" (matching
+// a bridge's group or tags) or "default" (DEFAULT_INSTANCE, or every bridge
+// if unset).
+func (bm *BridgeManager) Candidates(target string) []*BridgeConnection {
+	if target == "default" && bm.config.DefaultInstance != "" && bm.config.DefaultInstance != "default" {
+		target = bm.config.DefaultInstance
+	}
+
+	bm.mutex.RLock()
+	defer bm.mutex.RUnlock()
+
+	if bridge, ok := bm.connections[target]; ok {
+		return []*BridgeConnection{bridge}
+	}
+
+	group, isGroup := strings.CutPrefix(target, "group:")
+	return bm.healthy(func(bridge *BridgeConnection) bool {
+		switch {
+		case isGroup:
+			return bridge.inGroup(group)
+		case target == "default":
+			return true
+		}
+		return bridge.Name == target
+	})
+}
+
+// WithCapability returns the online bridges declaring capability, least busy first
+func (bm *BridgeManager) WithCapability(capability string) []*BridgeConnection {
+	bm.mutex.RLock()
+	defer bm.mutex.RUnlock()
+
+	return bm.healthy(func(bridge *BridgeConnection) bool {
+		return containsString(bridge.Capabilities, capability)
+	})
+}
+
+// healthy returns online bridges with a recent heartbeat matching match,
+// healthy before degraded, then least busy first. The caller holds bm.mutex.
+func (bm *BridgeManager) healthy(match func(*BridgeConnection) bool) []*BridgeConnection {
+	var bridges []*BridgeConnection
+	for _, bridge := range bm.connections {
+		if status, lastPing := bridge.state(); status != "online" || time.Since(lastPing) > 60*time.Second {
+			continue
+		}
+		if match(bridge) {
+			bridges = append(bridges, bridge)
+		}
+	}
+	degraded := make(map[*BridgeConnection]bool, len(bridges))
+	for _, bridge := range bridges {
+		degraded[bridge] = bridge.degraded()
+	}
+	sort.Slice(bridges, func(i, j int) bool {
+		if degraded[bridges[i]] != degraded[bridges[j]] {
+			return !degraded[bridges[i]]
+		}
+		a, b := bridges[i].InFlight(), bridges[j].InFlight()
+		if a != b {
+			return a < b
+		}
+		return bridges[i].ConnectedAt.Before(bridges[j].ConnectedAt)
+	})
+	return bridges
+}
+
+// DepartedName returns the name of a recently disconnected bridge
+func (bm *BridgeManager) DepartedName(id string) string {
+	bm.mutex.RLock()
+	defer bm.mutex.RUnlock()
+	return bm.departed[id].name
+}
+
 // removeBridge removes a bridge from the connections
 func (bm *BridgeManager) removeBridge(id string) {
 	bm.mutex.Lock()
@@ -281,17 +944,30 @@ func (bm *BridgeManager) removeBridge(id string) {
 
 	if bridge, exists := bm.connections[id]; exists {
 		log.Printf("Bridge disconnected: %s (%s)", bridge.Name, bridge.ID)
-		// Close all per-request channels
+		if bm.fileStore != nil {
+			bm.fileStore.AbortUploads(bridge.ID + "/")
+		}
+		// End all per-request streams
 		bridge.requestMu.Lock()
-		for reqID, ch := range bridge.requestChans {
-			close(ch.ResponseCh)
-			close(ch.ErrorCh)
-			close(ch.FileCh)
+		for reqID, stream := range bridge.requestChans {
+			stream.Close()
 			delete(bridge.requestChans, reqID)
 		}
 		bridge.requestMu.Unlock()
 		delete(bm.connections, id)
+		bm.rememberDeparted(bridge)
+	}
+}
+
+// rememberDeparted records a bridge's name for a day after it disconnects
+func (bm *BridgeManager) rememberDeparted(bridge *BridgeConnection) {
+	now := time.Now()
+	for id, d := range bm.departed {
+		if now.Sub(d.at) > 24*time.Hour {
+			delete(bm.departed, id)
+		}
 	}
+	bm.departed[bridge.ID] = departedBridge{name: bridge.Name, at: now}
 }
 
 // heartbeatChecker checks for inactive bridges and removes them
@@ -303,11 +979,32 @@ func (bm *BridgeManager) heartbeatChecker() {
 		select {
 		case <-ticker.C:
 			bm.checkHeartbeats()
+			bm.probeAll()
 		}
 	}
 }
 
-// checkHeartbeats checks for inactive bridges
+// probe sends a heartbeat ping to measure the bridge's round-trip time
+func (bm *BridgeManager) probe(bridge *BridgeConnection) {
+	bridge.SendControl(HeartbeatMessage{Type: MsgTypeHeartbeat, Ping: bridge.health.nextPing()})
+}
+
+// probeAll pings every connected bridge
+func (bm *BridgeManager) probeAll() {
+	bm.mutex.RLock()
+	bridges := make([]*BridgeConnection, 0, len(bm.connections))
+	for _, bridge := range bm.connections {
+		bridges = append(bridges, bridge)
+	}
+	bm.mutex.RUnlock()
+
+	for _, bridge := range bridges {
+		go bm.probe(bridge)
+	}
+}
+
+// checkHeartbeats closes the links of inactive bridges. Closing ends their
+// read loop, which tears them down through removeBridge.
 func (bm *BridgeManager) checkHeartbeats() {
 	bm.mutex.Lock()
 	defer bm.mutex.Unlock()
@@ -315,18 +1012,17 @@ func (bm *BridgeManager) checkHeartbeats() {
 	now := time.Now()
 	timeout := 60 * time.Second
 
-	for id, bridge := range bm.connections {
-		if now.Sub(bridge.LastPing) > timeout {
+	for _, bridge := range bm.connections {
+		if _, lastPing := bridge.state(); now.Sub(lastPing) > timeout {
 			log.Printf("Bridge timeout: %s (%s)", bridge.Name, bridge.ID)
-			bridge.Status = "offline"
-			bridge.Conn.Close()
-			delete(bm.connections, id)
+			bridge.setStatus("offline")
+			bridge.Close()
 		}
 	}
 }
 
 // SendChatRequest sends a chat request to a specific bridge
-func (bm *BridgeManager) SendChatRequest(bridgeID, requestID string, messages []ChatMessage, user string) error {
+func (bm *BridgeManager) SendChatRequest(bridgeID, requestID string, messages []ChatMessage, opts ChatOptions, window int) error {
 	bridge := bm.GetBridge(bridgeID)
 	if bridge == nil {
 		return fmt.Errorf("bridge not found: %s", bridgeID)
@@ -335,11 +1031,74 @@ func (bm *BridgeManager) SendChatRequest(bridgeID, requestID string, messages []
 	chatReq := ChatRequestMessage{
 		Type:      MsgTypeChatRequest,
 		RequestID: requestID,
-		Messages:  messages,
-		User:      user,
+		Messages:  toBridgeMessages(messages, bm.config.PublicURL),
+		User:      opts.User,
+		Agent:     opts.Agent,
+		Model:     opts.Model,
+		Window:    window,
+	}
+	if bridge.link.Typed() {
+		if err := bm.streamAttachments(bridge, requestID, chatReq.Messages); err != nil {
+			return err
+		}
 	}
 
-	return SendMessage(bridge.Conn, chatReq)
+	return bridge.Send(chatReq)
+}
+
+// attachmentChunkSize is the size of binary chunks carrying attachment data
+const attachmentChunkSize = 64 << 10
+
+// streamAttachments sends inlined attachment data as binary streams ahead of
+// the chat request and replaces Data with the stream ID
+func (bm *BridgeManager) streamAttachments(bridge *BridgeConnection, requestID string, messages []BridgeChatMessage) error {
+	for i := range messages {
+		if len(messages[i].Attachments) == 0 {
+			continue
+		}
+		// Copy: the caller's attachments keep their data for failover to other bridges
+		atts := append([]ChatAttachment(nil), messages[i].Attachments...)
+		for j := range atts {
+			data := atts[j].Data
+			if len(data) == 0 {
+				continue
+			}
+			streamID := atomic.AddUint32(&bridge.nextStream, 1)
+			err := bridge.Send(StreamOpenMessage{
+				Type:      MsgTypeStreamOpen,
+				StreamID:  streamID,
+				Kind:      StreamKindAttachment,
+				RequestID: requestID,
+				FileID:    atts[j].FileID,
+				Filename:  atts[j].Name,
+				MimeType:  atts[j].MimeType,
+				Size:      int64(len(data)),
+			})
+			for off := 0; err == nil && off < len(data); off += attachmentChunkSize {
+				end := min(off+attachmentChunkSize, len(data))
+				err = bridge.SendBinary(streamID, end == len(data), data[off:end])
+			}
+			if err != nil {
+				return fmt.Errorf("failed to stream attachment %s: %v", atts[j].FileID, err)
+			}
+			atts[j].Data = nil
+			atts[j].StreamID = streamID
+		}
+		messages[i].Attachments = atts
+	}
+	return nil
+}
+
+// SendCredit lets the bridge send credit more frames for a request
+func (bm *BridgeManager) SendCredit(bridge *BridgeConnection, requestID string, credit int) error {
+	return bridge.SendControl(ChatCreditMessage{Type: MsgTypeChatCredit, RequestID: requestID, Credit: credit})
+}
+
+// CancelChatRequest tells the bridge to stop working on a request
+func (bm *BridgeManager) CancelChatRequest(bridge *BridgeConnection, requestID, reason string) {
+	if err := bridge.SendControl(ChatCancelMessage{Type: MsgTypeChatCancel, RequestID: requestID, Reason: reason}); err != nil {
+		log.Printf("Failed to cancel request %s on bridge %s: %v", requestID, bridge.ID, err)
+	}
 }
 
 // generateID generates a unique ID for bridge connections
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// BridgeGuard protects the bridge listener from unauthenticated clients: it
+// caps concurrent connections per IP and makes an IP that fails to register
+// wait before trying again, doubling the wait with each failure until it is
+// banned.
+type BridgeGuard struct {
+	maxPerIP    int
+	maxFailures int
+	ban         time.Duration
+
+	mu        sync.Mutex
+	conns     map[string]int
+	failures  map[string]*authFailures
+	lastSweep time.Time
+}
+
+type authFailures struct {
+	count        int
+	blockedUntil time.Time
+}
+
+// NewBridgeGuard creates a guard from BRIDGE_MAX_CONNS_PER_IP,
+// BRIDGE_MAX_AUTH_FAILURES and BRIDGE_BAN_MINUTES
+func NewBridgeGuard(config *Config) *BridgeGuard {
+	return &BridgeGuard{
+		maxPerIP:    config.BridgeMaxConnsPerIP,
+		maxFailures: config.BridgeMaxAuthFailures,
+		ban:         time.Duration(config.BridgeBanMinutes) * time.Minute,
+		conns:       make(map[string]int),
+		failures:    make(map[string]*authFailures),
+		lastSweep:   time.Now(),
+	}
+}
+
+// Admit reserves a connection slot for ip. Callers that get true must call
+// Release when the connection ends.
+func (g *BridgeGuard) Admit(ip string) (bool, string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	g.sweep(now)
+
+	if f := g.failures[ip]; f != nil && now.Before(f.blockedUntil) {
+		return false, "blocked after failed registrations until " + f.blockedUntil.Format(time.RFC3339)
+	}
+	if g.maxPerIP > 0 && g.conns[ip] >= g.maxPerIP {
+		return false, "too many connections"
+	}
+	g.conns[ip]++
+	return true, ""
+}
+
+// Release frees a slot taken by Admit
+func (g *BridgeGuard) Release(ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.conns[ip]--; g.conns[ip] <= 0 {
+		delete(g.conns, ip)
+	}
+}
+
+// AuthFailed records a failed registration: the IP is blocked for 2^(n-1)
+// seconds after the n-th failure, and for the ban duration once it reaches
+// the failure limit
+func (g *BridgeGuard) AuthFailed(ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	f := g.failures[ip]
+	if f == nil {
+		f = &authFailures{}
+		g.failures[ip] = f
+	}
+	f.count++
+
+	block := g.ban
+	if g.maxFailures <= 0 || f.count < g.maxFailures {
+		block = time.Duration(1<<uint(min(f.count-1, 16))) * time.Second
+		if block > g.ban {
+			block = g.ban
+		}
+	} else {
+		log.Printf("[BridgeGuard] Banning %s for %s after %d failed registrations", ip, g.ban, f.count)
+	}
+	f.blockedUntil = time.Now().Add(block)
+}
+
+// AuthSucceeded forgets earlier failures of ip
+func (g *BridgeGuard) AuthSucceeded(ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.failures, ip)
+}
+
+// sweep drops failure records whose block has long expired
+func (g *BridgeGuard) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < 10*time.Minute {
+		return
+	}
+	g.lastSweep = now
+	for ip, f := range g.failures {
+		if now.Sub(f.blockedUntil) > g.ban {
+			delete(g.failures, ip)
+		}
+	}
+}
+
+// remoteIP returns the IP part of a connection's remote address
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Health thresholds: a bridge is reported "degraded" (and routed to only
+// after healthy bridges) when any of them is crossed
+const (
+	healthLateHeartbeat = 45 * time.Second       // heartbeats are expected every 30s, dropped after 60s
+	healthSlowRTT       = 2 * time.Second        // average heartbeat round trip
+	healthErrorRate     = 0.5                    // share of failed recent requests
+	healthMinRequests   = 4                      // recent requests needed before the error rate counts
+	healthWindow        = 20                     // recent requests remembered
+	healthRTTWeight     = 0.2                    // weight of a new sample in the average RTT
+	healthRTTPenaltyMax = 30                     // score points lost to a slow link
+	healthErrPenaltyMax = 60                     // score points lost to a 100% error rate
+	healthLatePenalty   = 40                     // score points lost to a late heartbeat
+	healthRTTPenaltyAt  = 100 * time.Millisecond // RTT costing one score point
+)
+
+// bridgeHealth tracks a bridge's heartbeat round trips, request outcomes and
+// last activity
+type bridgeHealth struct {
+	mu           sync.Mutex
+	pingSeq      int64
+	pingSent     time.Time
+	rtt, avgRTT  time.Duration
+	lastActivity time.Time
+	outcomes     []bool // recent requests, true = failed
+	requests     int64
+	errors       int64
+}
+
+// HealthInfo is a bridge's health as reported by /api/instances
+type HealthInfo struct {
+	Score         int       `json:"score"` // 0-100
+	RTTMs         int64     `json:"rttMs,omitempty"`
+	AvgRTTMs      int64     `json:"avgRttMs,omitempty"`
+	ErrorRate     float64   `json:"errorRate"` // over the last 20 requests
+	Requests      int64     `json:"requests"`
+	Errors        int64     `json:"errors"`
+	LastHeartbeat time.Time `json:"lastHeartbeat"`
+	LastActivity  time.Time `json:"lastActivity"`
+	Degraded      []string  `json:"degraded,omitempty"` // reasons
+}
+
+// nextPing starts a heartbeat probe and returns its sequence number
+func (h *bridgeHealth) nextPing() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.pingSeq++
+	h.pingSent = time.Now()
+	return h.pingSeq
+}
+
+// pong records the answer to probe seq; stale answers are ignored
+func (h *bridgeHealth) pong(seq int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if seq != h.pingSeq || h.pingSent.IsZero() {
+		return
+	}
+	h.rtt = time.Since(h.pingSent)
+	h.pingSent = time.Time{}
+	if h.avgRTT == 0 {
+		h.avgRTT = h.rtt
+	} else {
+		h.avgRTT += time.Duration(healthRTTWeight * float64(h.rtt-h.avgRTT))
+	}
+}
+
+// touch records that the bridge sent something
+func (h *bridgeHealth) touch() {
+	h.mu.Lock()
+	h.lastActivity = time.Now()
+	h.mu.Unlock()
+}
+
+// finished records the outcome of a request. Requests the app gave up on
+// don't count against the bridge.
+func (h *bridgeHealth) finished(ctx context.Context, err error) {
+	if err != nil && ctx.Err() == context.Canceled {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.requests++
+	if err != nil {
+		h.errors++
+	}
+	h.outcomes = append(h.outcomes, err != nil)
+	if len(h.outcomes) > healthWindow {
+		h.outcomes = h.outcomes[1:]
+	}
+}
+
+// report scores the bridge given the time of its last heartbeat
+func (h *bridgeHealth) report(lastPing time.Time) HealthInfo {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	info := HealthInfo{
+		Score:         100,
+		RTTMs:         h.rtt.Milliseconds(),
+		AvgRTTMs:      h.avgRTT.Milliseconds(),
+		Requests:      h.requests,
+		Errors:        h.errors,
+		LastHeartbeat: lastPing,
+		LastActivity:  h.lastActivity,
+	}
+
+	failed := 0
+	for _, f := range h.outcomes {
+		if f {
+			failed++
+		}
+	}
+	if len(h.outcomes) > 0 {
+		info.ErrorRate = float64(failed) / float64(len(h.outcomes))
+	}
+	// An unanswered probe older than the threshold counts as a slow round trip
+	pending := time.Duration(0)
+	if !h.pingSent.IsZero() && h.rtt > 0 {
+		pending = time.Since(h.pingSent)
+	}
+
+	info.Score -= min(int(max(h.avgRTT, pending)/healthRTTPenaltyAt), healthRTTPenaltyMax)
+	if len(h.outcomes) >= healthMinRequests {
+		info.Score -= int(info.ErrorRate * healthErrPenaltyMax)
+		if info.ErrorRate >= healthErrorRate {
+			info.Degraded = append(info.Degraded, "errors")
+		}
+	}
+	if h.avgRTT > healthSlowRTT || pending > healthSlowRTT {
+		info.Degraded = append(info.Degraded, "slow")
+	}
+	if time.Since(lastPing) > healthLateHeartbeat {
+		info.Score -= healthLatePenalty
+		info.Degraded = append(info.Degraded, "heartbeat late")
+	}
+	info.Score = max(info.Score, 0)
+	return info
+}
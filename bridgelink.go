@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// bridgeLink carries frames between the server and one bridge: length-
+// prefixed frames on the TCP listener, or WebSocket messages on
+// /api/bridge/ws. Everything above it (registration, heartbeats, routing)
+// is shared.
+type bridgeLink interface {
+	// ReadFrame returns the next frame as FrameJSON or FrameBinary, possibly
+	// with FrameCompressed set
+	ReadFrame(maxSize int) (byte, []byte, error)
+	WriteFrame(frameType byte, payload []byte) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	Close() error
+	RemoteAddr() string
+
+	// Typed reports whether frames carry a type, i.e. binary chunks can be sent
+	Typed() bool
+	// SetTyped switches to typed frames after the registered reply
+	SetTyped()
+	// CanDeflate reports whether the link can flag compressed frames
+	CanDeflate() bool
+}
+
+// tcpLink is a bridge on the TCP listener. It starts with JSON-only framing
+// and switches to typed frames if the bridge asked for binary frames.
+type tcpLink struct {
+	conn  net.Conn
+	typed bool
+}
+
+func (l *tcpLink) ReadFrame(maxSize int) (byte, []byte, error) {
+	if !l.typed {
+		data, err := ReadMessage(l.conn, maxSize)
+		return FrameJSON, data, err
+	}
+	return ReadFrame(l.conn, maxSize)
+}
+
+func (l *tcpLink) WriteFrame(frameType byte, payload []byte) error {
+	if !l.typed {
+		if frameType != FrameJSON {
+			return fmt.Errorf("binary frames not negotiated")
+		}
+		return SendMessage(l.conn, json.RawMessage(payload))
+	}
+	return WriteFrame(l.conn, frameType, payload)
+}
+
+func (l *tcpLink) SetReadDeadline(t time.Time) error  { return l.conn.SetReadDeadline(t) }
+func (l *tcpLink) SetWriteDeadline(t time.Time) error { return l.conn.SetWriteDeadline(t) }
+func (l *tcpLink) Close() error                       { return l.conn.Close() }
+func (l *tcpLink) RemoteAddr() string                 { return l.conn.RemoteAddr().String() }
+func (l *tcpLink) Typed() bool                        { return l.typed }
+func (l *tcpLink) SetTyped()                          { l.typed = true }
+func (l *tcpLink) CanDeflate() bool                   { return true }
+
+// wsLink is a bridge on the WebSocket endpoint: JSON messages travel as text
+// messages and binary chunks as binary messages. Compression is left to the
+// WebSocket permessage-deflate extension.
+type wsLink struct {
+	conn *websocket.Conn
+}
+
+func (l *wsLink) ReadFrame(maxSize int) (byte, []byte, error) {
+	l.conn.SetReadLimit(int64(maxSize))
+	msgType, data, err := l.conn.ReadMessage()
+	if err != nil {
+		if errors.Is(err, websocket.ErrReadLimit) {
+			return 0, nil, fmt.Errorf("%w: max %d bytes", ErrFrameTooLarge, maxSize)
+		}
+		return 0, nil, err
+	}
+	if msgType == websocket.BinaryMessage {
+		return FrameBinary, data, nil
+	}
+	return FrameJSON, data, nil
+}
+
+func (l *wsLink) WriteFrame(frameType byte, payload []byte) error {
+	if frameType == FrameBinary {
+		return l.conn.WriteMessage(websocket.BinaryMessage, payload)
+	}
+	return l.conn.WriteMessage(websocket.TextMessage, payload)
+}
+
+func (l *wsLink) SetReadDeadline(t time.Time) error  { return l.conn.SetReadDeadline(t) }
+func (l *wsLink) SetWriteDeadline(t time.Time) error { return l.conn.SetWriteDeadline(t) }
+func (l *wsLink) Close() error                       { return l.conn.Close() }
+func (l *wsLink) RemoteAddr() string                 { return l.conn.RemoteAddr().String() }
+func (l *wsLink) Typed() bool                        { return true }
+func (l *wsLink) SetTyped()                          {}
+func (l *wsLink) CanDeflate() bool                   { return false }
+
+var bridgeWSUpgrader = websocket.Upgrader{
+	CheckOrigin:       func(r *http.Request) bool { return true },
+	EnableCompression: true,
+}
+
+// ServeWebSocket GET /api/bridge/ws - bridge connection over WebSocket for
+// networks that block the TCP port. ip is the client address used for the
+// connection caps and auth backoff.
+func (bm *BridgeManager) ServeWebSocket(w http.ResponseWriter, r *http.Request, ip string) {
+	if ok, reason := bm.guard.Admit(ip); !ok {
+		log.Printf("Rejected bridge WebSocket from %s: %s", ip, reason)
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+	defer bm.guard.Release(ip)
+
+	conn, err := bridgeWSUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Bridge WebSocket upgrade error: %v", err)
+		return
+	}
+	link := &wsLink{conn: conn}
+	defer link.Close()
+
+	bm.serveBridge(link, ip)
+}
@@ -19,15 +19,117 @@ type Config struct {
 	LocalOpenclawURL  string // Local OpenClaw gateway URL (e.g. http://localhost:18789)
 	LocalOpenclawToken string // Bearer token for local OpenClaw
 	LocalOpenclawName  string // Display name for local instance
+
+	// Background conversation titling / summarization
+	SummarizeEnabled    bool   // Generate titles and rolling summaries via the conversation's instance
+	SummarizeInstance   string // Fallback instance for conversations without one (e.g. "local")
+	SummaryEvery        int    // Refresh the rolling summary every N new messages (0 = titles only)
+	ContextKeepMessages int    // Replace older context with the summary beyond this many messages (0 = off)
+
+	TrashRetentionDays int // Days a deleted conversation stays in the trash (0 = keep until emptied)
+
+	// Retention policies and quotas for DataDir
+	ConversationRetentionDays int // Move conversations untouched for N days to the trash (0 = off)
+	APKKeepBuilds             int // Number of APK builds to keep (0 = keep all)
+	FCMTokenRetentionDays     int // Drop FCM tokens not re-registered for N days (0 = off)
+	DataQuotaMB               int // Total DataDir quota (0 = unlimited)
+	ConversationQuotaMB       int // Conversations quota (0 = unlimited)
+	APKQuotaMB                int // APK builds quota (0 = unlimited)
+	JanitorIntervalMinutes    int // How often retention policies are applied
+
+	// File store
+	FileTTLHours int // Hours a stored attachment is kept (0 = forever)
+	FileMaxMB    int // Maximum size of a single stored file (0 = unlimited)
+	FilesQuotaMB int // Stored attachments quota (0 = unlimited)
+
+	PublicURL          string // Externally reachable base URL (e.g. https://voicechat.tyranno.xyz), used in attachment URLs
+	AttachmentInlineKB int    // Attachments up to this size are sent to bridges inline
+
+	UsagePrices string // "model=prompt/completion,..." in USD per million tokens, for cost reports
+
+	DefaultInstance    string // What instanceId "default" means: a bridge ID, "group:<name>" or "local" (empty = any bridge)
+	QueueTTLHours      int    // Hours a request queued for an offline bridge waits before expiring (0 = forever)
+	WakeMACs           string // "bridge-name=mac,..." for bridges that do not report their MAC
+	WakeTimeoutSeconds int    // How long a chat waits for a woken bridge to register
+
+	// Relay timeouts (seconds); requests may override them up to RelayTimeoutLimitSeconds
+	RelayFirstByteTimeoutSeconds int // Until the instance sends anything
+	RelayIdleTimeoutSeconds      int // Between deltas, tool events or files
+	RelayTotalTimeoutSeconds     int // Whole request
+	RelayTimeoutLimitSeconds     int // Upper bound for per-request overrides
+	FileDrainSeconds             int // Wait for late file responses after the answer is done (holds back [DONE])
+	SSEKeepaliveSeconds          int // Interval of SSE keepalive comments on idle streams (0 = off)
+	ChatFlowWindow               int // Frames a bridge may send per request ahead of the app (0 = no flow control)
+	RequestBufferKB              int // Buffered answer per request before a bridge ignoring the window is cut off
+	BridgeWriteTimeoutSeconds    int // A bridge not accepting a frame for this long is disconnected (0 = no deadline)
+	RPCTimeoutSeconds            int // How long an RPC waits for the bridge's answer
+
+	// Bridge link compression
+	BridgeCompression      bool // Accept deflate compression with bridges that offer it
+	BridgeCompressMinBytes int  // Smallest frame worth compressing
+
+	// Bridge listener hardening
+	BridgeMaxFrameKB             int // Largest frame accepted from a registered bridge
+	BridgeRegisterTimeoutSeconds int // Time a new connection has to register
+	BridgeMaxConnsPerIP          int // Concurrent bridge connections per IP (0 = unlimited)
+	BridgeMaxAuthFailures        int // Failed registrations before an IP is banned
+	BridgeBanMinutes             int // Ban duration, also the cap of the backoff between failures
+
+	// Rate limiting (requests per minute per device and per IP, 0 = off)
+	ChatRatePerMinute     int
+	YouTubeRatePerMinute  int
+	PushRatePerMinute     int
+	RPCRatePerMinute      int
+	DeviceDailyTokenQuota int  // Tokens a device, and a client IP, may use per day (0 = unlimited)
+	TrustProxyHeaders     bool // Take the client IP from X-Forwarded-For / X-Real-IP
+
+	// Admin API (/admin) and `admin` CLI, disabled while empty
+	AdminToken string
+	// Instance RPC token for the app; RPC is disabled while it and AdminToken are empty
+	RPCToken string
 }
 
 // LoadConfig loads configuration from environment variables
 func LoadConfig() *Config {
 	config := &Config{
-		Port:        8080,
-		BridgePort:  9090,
-		BridgeToken: "default-bridge-token",
-		DataDir:     "/opt/voicechat/data",
+		Port:         8080,
+		BridgePort:   9090,
+		BridgeToken:  "default-bridge-token",
+		DataDir:      "/opt/voicechat/data",
+		SummaryEvery: 20,
+
+		TrashRetentionDays:     30,
+		APKKeepBuilds:          5,
+		JanitorIntervalMinutes: 60,
+		FileTTLHours:           7 * 24,
+		FileMaxMB:              100,
+		AttachmentInlineKB:     512,
+
+		QueueTTLHours:      24,
+		WakeTimeoutSeconds: 90,
+
+		RelayFirstByteTimeoutSeconds: 120,
+		RelayIdleTimeoutSeconds:      120,
+		RelayTotalTimeoutSeconds:     600,
+		RelayTimeoutLimitSeconds:     1800,
+		SSEKeepaliveSeconds:          15,
+		ChatFlowWindow:               64,
+		RequestBufferKB:              8 * 1024,
+		BridgeWriteTimeoutSeconds:    10,
+		RPCTimeoutSeconds:            30,
+		BridgeCompression:            true,
+		BridgeCompressMinBytes:       1024,
+
+		BridgeMaxFrameKB:             16 * 1024,
+		BridgeRegisterTimeoutSeconds: 10,
+		BridgeMaxConnsPerIP:          10,
+		BridgeMaxAuthFailures:        5,
+		BridgeBanMinutes:             15,
+
+		ChatRatePerMinute:    30,
+		YouTubeRatePerMinute: 10,
+		PushRatePerMinute:    10,
+		RPCRatePerMinute:     30,
 	}
 
 	if port := os.Getenv("PORT"); port != "" {
@@ -82,5 +184,230 @@ func LoadConfig() *Config {
 		config.LocalOpenclawName = "서버 (GCP)"
 	}
 
+	// Conversation summarization
+	if v := os.Getenv("SUMMARIZE_ENABLED"); v == "true" || v == "1" {
+		config.SummarizeEnabled = true
+	}
+	if v := os.Getenv("SUMMARIZE_INSTANCE"); v != "" {
+		config.SummarizeInstance = v
+	}
+	if v := os.Getenv("SUMMARY_EVERY"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.SummaryEvery = n
+		}
+	}
+	if v := os.Getenv("CONTEXT_KEEP_MESSAGES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.ContextKeepMessages = n
+		}
+	}
+
+	if v := os.Getenv("TRASH_RETENTION_DAYS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.TrashRetentionDays = n
+		}
+	}
+
+	// Retention and quotas
+	if v := os.Getenv("CONVERSATION_RETENTION_DAYS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.ConversationRetentionDays = n
+		}
+	}
+	if v := os.Getenv("APK_KEEP_BUILDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.APKKeepBuilds = n
+		}
+	}
+	if v := os.Getenv("FCM_TOKEN_RETENTION_DAYS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.FCMTokenRetentionDays = n
+		}
+	}
+	if v := os.Getenv("DATA_QUOTA_MB"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.DataQuotaMB = n
+		}
+	}
+	if v := os.Getenv("CONVERSATION_QUOTA_MB"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.ConversationQuotaMB = n
+		}
+	}
+	if v := os.Getenv("APK_QUOTA_MB"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.APKQuotaMB = n
+		}
+	}
+	if v := os.Getenv("JANITOR_INTERVAL_MINUTES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.JanitorIntervalMinutes = n
+		}
+	}
+
+	// File store
+	if v := os.Getenv("FILE_TTL_HOURS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.FileTTLHours = n
+		}
+	}
+	if v := os.Getenv("FILE_MAX_MB"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.FileMaxMB = n
+		}
+	}
+	if v := os.Getenv("FILES_QUOTA_MB"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.FilesQuotaMB = n
+		}
+	}
+	if v := os.Getenv("PUBLIC_URL"); v != "" {
+		config.PublicURL = v
+	}
+	if v := os.Getenv("ATTACHMENT_INLINE_KB"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.AttachmentInlineKB = n
+		}
+	}
+	if v := os.Getenv("USAGE_PRICES"); v != "" {
+		config.UsagePrices = v
+	}
+	if v := os.Getenv("DEFAULT_INSTANCE"); v != "" {
+		config.DefaultInstance = v
+	}
+	if v := os.Getenv("WAKE_MACS"); v != "" {
+		config.WakeMACs = v
+	}
+	if v := os.Getenv("WAKE_TIMEOUT_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.WakeTimeoutSeconds = n
+		}
+	}
+	// Relay timeouts
+	if v := os.Getenv("RELAY_FIRST_BYTE_TIMEOUT_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			config.RelayFirstByteTimeoutSeconds = n
+		}
+	}
+	if v := os.Getenv("RELAY_IDLE_TIMEOUT_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			config.RelayIdleTimeoutSeconds = n
+		}
+	}
+	if v := os.Getenv("RELAY_TOTAL_TIMEOUT_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			config.RelayTotalTimeoutSeconds = n
+		}
+	}
+	if v := os.Getenv("RELAY_TIMEOUT_LIMIT_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			config.RelayTimeoutLimitSeconds = n
+		}
+	}
+	if v := os.Getenv("FILE_DRAIN_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			config.FileDrainSeconds = n
+		}
+	}
+	if v := os.Getenv("SSE_KEEPALIVE_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.SSEKeepaliveSeconds = n
+		}
+	}
+	if v := os.Getenv("CHAT_FLOW_WINDOW"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.ChatFlowWindow = n
+		}
+	}
+	if v := os.Getenv("REQUEST_BUFFER_KB"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.RequestBufferKB = n
+		}
+	}
+	if v := os.Getenv("BRIDGE_WRITE_TIMEOUT_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.BridgeWriteTimeoutSeconds = n
+		}
+	}
+	if v := os.Getenv("RPC_TIMEOUT_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			config.RPCTimeoutSeconds = n
+		}
+	}
+	if v := os.Getenv("BRIDGE_COMPRESSION"); v == "false" || v == "0" {
+		config.BridgeCompression = false
+	}
+	if v := os.Getenv("BRIDGE_COMPRESS_MIN_BYTES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.BridgeCompressMinBytes = n
+		}
+	}
+	if v := os.Getenv("BRIDGE_MAX_FRAME_KB"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			config.BridgeMaxFrameKB = n
+		}
+	}
+	if v := os.Getenv("BRIDGE_REGISTER_TIMEOUT_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			config.BridgeRegisterTimeoutSeconds = n
+		}
+	}
+	if v := os.Getenv("BRIDGE_MAX_CONNS_PER_IP"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.BridgeMaxConnsPerIP = n
+		}
+	}
+	if v := os.Getenv("BRIDGE_MAX_AUTH_FAILURES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.BridgeMaxAuthFailures = n
+		}
+	}
+	if v := os.Getenv("BRIDGE_BAN_MINUTES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			config.BridgeBanMinutes = n
+		}
+	}
+	if v := os.Getenv("QUEUE_TTL_HOURS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.QueueTTLHours = n
+		}
+	}
+
+	// Rate limiting
+	if v := os.Getenv("CHAT_RATE_PER_MINUTE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.ChatRatePerMinute = n
+		}
+	}
+	if v := os.Getenv("YOUTUBE_RATE_PER_MINUTE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.YouTubeRatePerMinute = n
+		}
+	}
+	if v := os.Getenv("PUSH_RATE_PER_MINUTE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.PushRatePerMinute = n
+		}
+	}
+	if v := os.Getenv("RPC_RATE_PER_MINUTE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.RPCRatePerMinute = n
+		}
+	}
+	if v := os.Getenv("DEVICE_DAILY_TOKEN_QUOTA"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.DeviceDailyTokenQuota = n
+		}
+	}
+	if v := os.Getenv("TRUST_PROXY_HEADERS"); v == "true" || v == "1" {
+		config.TrustProxyHeaders = true
+	}
+	if v := os.Getenv("ADMIN_TOKEN"); v != "" {
+		config.AdminToken = v
+	}
+	if v := os.Getenv("RPC_TOKEN"); v != "" {
+		config.RPCToken = v
+	}
+
 	return config
 }
\ No newline at end of file
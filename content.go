@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Content part types (OpenAI-compatible)
+const (
+	PartTypeText       = "text"
+	PartTypeImageURL   = "image_url"
+	PartTypeInputAudio = "input_audio"
+)
+
+// ContentPart is one element of an OpenAI-style content array
+type ContentPart struct {
+	Type       string          `json:"type"`
+	Text       string          `json:"text,omitempty"`
+	ImageURL   *ImageURLPart   `json:"image_url,omitempty"`
+	InputAudio *InputAudioPart `json:"input_audio,omitempty"`
+}
+
+// ImageURLPart references an image by URL (http(s), data: or /api/files/...)
+type ImageURLPart struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// InputAudioPart carries base64 audio inline
+type InputAudioPart struct {
+	Data   string `json:"data"`
+	Format string `json:"format"` // wav, mp3, ...
+}
+
+// MessageContent is a message's content: a plain string (what older app
+// builds send) or an array of parts. It marshals back to the same form.
+type MessageContent struct {
+	Text  string
+	Parts []ContentPart
+}
+
+// TextContent returns string content
+func TextContent(text string) MessageContent {
+	return MessageContent{Text: text}
+}
+
+// MarshalJSON encodes string content as a JSON string and parts as an array
+func (c MessageContent) MarshalJSON() ([]byte, error) {
+	if c.Parts == nil {
+		return json.Marshal(c.Text)
+	}
+	return json.Marshal(c.Parts)
+}
+
+// UnmarshalJSON accepts a JSON string, an array of parts or null
+func (c *MessageContent) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case len(data) == 0 || string(data) == "null":
+		*c = MessageContent{}
+		return nil
+	case data[0] == '"':
+		c.Parts = nil
+		return json.Unmarshal(data, &c.Text)
+	case data[0] == '[':
+		var parts []ContentPart
+		if err := json.Unmarshal(data, &parts); err != nil {
+			return err
+		}
+		*c = MessageContent{Parts: parts}
+		return nil
+	}
+	return fmt.Errorf("content must be a string or an array of parts")
+}
+
+// String returns the text of the content; text parts are joined by newlines
+func (c MessageContent) String() string {
+	if c.Parts == nil {
+		return c.Text
+	}
+	var texts []string
+	for _, p := range c.Parts {
+		if p.Type == PartTypeText && p.Text != "" {
+			texts = append(texts, p.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// IsEmpty reports whether the content has neither text nor parts
+func (c MessageContent) IsEmpty() bool {
+	return c.Text == "" && len(c.Parts) == 0
+}
+
+// IsMultimodal reports whether the content has any non-text part
+func (c MessageContent) IsMultimodal() bool {
+	for _, p := range c.Parts {
+		if p.Type != PartTypeText {
+			return true
+		}
+	}
+	return false
+}
+
+// Equal reports whether two contents are the same
+func (c MessageContent) Equal(other MessageContent) bool {
+	a, _ := json.Marshal(c)
+	b, _ := json.Marshal(other)
+	return bytes.Equal(a, b)
+}
+
+// Validate checks that every part is well-formed
+func (c MessageContent) Validate() error {
+	for i, p := range c.Parts {
+		switch p.Type {
+		case PartTypeText:
+		case PartTypeImageURL:
+			if p.ImageURL == nil || p.ImageURL.URL == "" {
+				return fmt.Errorf("part[%d]: image_url.url is required", i)
+			}
+		case PartTypeInputAudio:
+			if p.InputAudio == nil || p.InputAudio.Data == "" || p.InputAudio.Format == "" {
+				return fmt.Errorf("part[%d]: input_audio.data and input_audio.format are required", i)
+			}
+		default:
+			return fmt.Errorf("part[%d]: unsupported type '%s'", i, p.Type)
+		}
+	}
+	return nil
+}
+
+// BridgeChatMessage is how a chat message travels to a bridge. Content stays
+// a plain string so bridges that predate content parts keep working; newer
+// bridges read Parts when present.
+type BridgeChatMessage struct {
+	Role        string           `json:"role"`
+	Content     string           `json:"content"`
+	Parts       []ContentPart    `json:"parts,omitempty"`
+	Attachments []ChatAttachment `json:"attachments,omitempty"`
+}
+
+// toBridgeMessages converts chat messages to their bridge wire form.
+// image_url parts pointing at /api/files/ are made absolute with publicURL,
+// as ResolveAttachments does for attachments.
+func toBridgeMessages(messages []ChatMessage, publicURL string) []BridgeChatMessage {
+	out := make([]BridgeChatMessage, len(messages))
+	for i, m := range messages {
+		out[i] = BridgeChatMessage{
+			Role:        m.Role,
+			Content:     m.Content.String(),
+			Attachments: m.Attachments,
+		}
+		if m.Content.IsMultimodal() {
+			parts := make([]ContentPart, len(m.Content.Parts))
+			for j, p := range m.Content.Parts {
+				if p.Type == PartTypeImageURL && p.ImageURL != nil {
+					p.ImageURL = &ImageURLPart{URL: publicFileURL(publicURL, p.ImageURL.URL), Detail: p.ImageURL.Detail}
+				}
+				parts[j] = p
+			}
+			out[i].Parts = parts
+		}
+	}
+	return out
+}
+
+// toOpenAIMessages converts chat messages for an OpenAI-compatible backend.
+// Attachments become image_url / input_audio parts where possible (inlined
+// as data up to ATTACHMENT_INLINE_KB since the backend cannot reach
+// server-relative URLs), otherwise a text note with the file's URL.
+func (fs *FileStore) toOpenAIMessages(messages []ChatMessage) []map[string]interface{} {
+	out := make([]map[string]interface{}, len(messages))
+	for i, m := range messages {
+		if len(m.Attachments) == 0 && !m.Content.IsMultimodal() {
+			out[i] = map[string]interface{}{"role": m.Role, "content": m.Content.String()}
+			continue
+		}
+
+		parts := m.Content.Parts
+		if parts == nil && m.Content.Text != "" {
+			parts = []ContentPart{{Type: PartTypeText, Text: m.Content.Text}}
+		}
+		converted := make([]ContentPart, 0, len(parts)+len(m.Attachments))
+		for _, p := range parts {
+			if p.Type == PartTypeImageURL && p.ImageURL != nil && strings.HasPrefix(p.ImageURL.URL, "/api/files/") {
+				p.ImageURL = &ImageURLPart{URL: fs.dataURL(p.ImageURL.URL), Detail: p.ImageURL.Detail}
+			}
+			converted = append(converted, p)
+		}
+		for _, att := range m.Attachments {
+			converted = append(converted, fs.attachmentPart(att))
+		}
+		out[i] = map[string]interface{}{"role": m.Role, "content": converted}
+	}
+	return out
+}
+
+// attachmentPart maps an attachment to the closest OpenAI content part.
+// Images too large to inline are passed by URL when PUBLIC_URL makes it
+// absolute.
+func (fs *FileStore) attachmentPart(att ChatAttachment) ContentPart {
+	data := att.Data
+	if data == nil && att.Size <= fs.inlineMax() {
+		data = fs.readFile(att.FileID)
+	}
+
+	switch {
+	case strings.HasPrefix(att.MimeType, "image/") && data != nil:
+		return ContentPart{Type: PartTypeImageURL, ImageURL: &ImageURLPart{
+			URL: "data:" + att.MimeType + ";base64," + base64.StdEncoding.EncodeToString(data),
+		}}
+	case strings.HasPrefix(att.MimeType, "image/") && !strings.HasPrefix(att.URL, "/"):
+		return ContentPart{Type: PartTypeImageURL, ImageURL: &ImageURLPart{URL: att.URL}}
+	case strings.HasPrefix(att.MimeType, "audio/") && data != nil:
+		return ContentPart{Type: PartTypeInputAudio, InputAudio: &InputAudioPart{
+			Data:   base64.StdEncoding.EncodeToString(data),
+			Format: audioFormat(att.MimeType),
+		}}
+	}
+	return ContentPart{Type: PartTypeText, Text: fmt.Sprintf("[첨부파일: %s (%s) %s]", att.Name, att.MimeType, att.URL)}
+}
+
+// dataURL turns a /api/files/{id}/{name} path into a data: URL. Files above
+// ATTACHMENT_INLINE_KB, or unavailable ones, keep their URL (absolute with
+// PUBLIC_URL).
+func (fs *FileStore) dataURL(path string) string {
+	id := strings.SplitN(strings.TrimPrefix(path, "/api/files/"), "/", 2)[0]
+	f, err := fs.Get(id)
+	if err != nil || f.Size > fs.inlineMax() {
+		return fs.absoluteURL(path)
+	}
+	data := fs.readFile(id)
+	if data == nil {
+		return fs.absoluteURL(path)
+	}
+	return "data:" + f.MimeType + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// readFile returns a stored file's contents, or nil if it is unavailable
+func (fs *FileStore) readFile(id string) []byte {
+	f, err := fs.Get(id)
+	if err != nil {
+		return nil
+	}
+	file, err := fs.Open(f)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// audioFormat maps an audio MIME type to an input_audio format name
+func audioFormat(mimeType string) string {
+	switch mimeType {
+	case "audio/mpeg", "audio/mp3":
+		return "mp3"
+	case "audio/wav", "audio/x-wav", "audio/wave":
+		return "wav"
+	}
+	return strings.TrimPrefix(mimeType, "audio/")
+}
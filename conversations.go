@@ -2,29 +2,107 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
+var (
+	ErrConversationNotFound = errors.New("conversation not found")
+	ErrMessageNotFound      = errors.New("message not found")
+	ErrReservedID           = errors.New("conversation ID is reserved")
+)
+
+// trashConversationID is the path segment of /api/conversations/trash, so no
+// conversation may use it as its ID
+const trashConversationID = "trash"
+
 // ConversationMeta holds metadata for a conversation
 type ConversationMeta struct {
-	ID        string `json:"id"`
-	Title     string `json:"title"`
-	CreatedAt int64  `json:"createdAt"`
-	UpdatedAt int64  `json:"updatedAt"`
-	MessageCount int `json:"messageCount"`
+	ID           string `json:"id"`
+	Title        string `json:"title"`
+	CreatedAt    int64  `json:"createdAt"`
+	UpdatedAt    int64  `json:"updatedAt"`
+	MessageCount int    `json:"messageCount"`
+	// ActiveLeafID is the last message of the branch returned by GetMessages
+	ActiveLeafID string `json:"activeLeafId,omitempty"`
+	// InstanceID is the bridge (or "local") that last served this conversation
+	InstanceID string `json:"instanceId,omitempty"`
+	// TitleSource is "user" for titles set via PATCH, "summary" for generated ones
+	TitleSource string `json:"titleSource,omitempty"`
+	// Summary is a rolling summary of the first SummaryMessageCount messages
+	Summary             string `json:"summary,omitempty"`
+	SummaryMessageCount int    `json:"summaryMessageCount,omitempty"`
+	// Organization
+	Tags     []string `json:"tags,omitempty"`
+	Folder   string   `json:"folder,omitempty"`
+	Pinned   bool     `json:"pinned,omitempty"`
+	Archived bool     `json:"archived,omitempty"`
+	// DeletedAt is set while the conversation sits in the trash
+	DeletedAt int64 `json:"deletedAt,omitempty"`
+}
+
+// ConversationFilter selects conversations for List. Zero values match everything
+// except archived conversations.
+type ConversationFilter struct {
+	Tag         string
+	Folder      string
+	Pinned      *bool
+	Archived    *bool  // nil = only unarchived
+	AllArchived bool   // include archived and unarchived
+	Query       string // case-insensitive title substring
+}
+
+// ConversationUpdate holds the optional fields accepted by PATCH /api/conversations/{id}
+type ConversationUpdate struct {
+	Title    *string   `json:"title"`
+	Tags     *[]string `json:"tags"`
+	Folder   *string   `json:"folder"`
+	Pinned   *bool     `json:"pinned"`
+	Archived *bool     `json:"archived"`
 }
 
-// ConversationMessage is a single chat message
+// matches reports whether meta passes the filter
+func (f ConversationFilter) matches(meta ConversationMeta) bool {
+	if f.Tag != "" && !containsString(meta.Tags, f.Tag) {
+		return false
+	}
+	if f.Folder != "" && meta.Folder != f.Folder {
+		return false
+	}
+	if f.Pinned != nil && meta.Pinned != *f.Pinned {
+		return false
+	}
+	if !f.AllArchived {
+		archived := false
+		if f.Archived != nil {
+			archived = *f.Archived
+		}
+		if meta.Archived != archived {
+			return false
+		}
+	}
+	if f.Query != "" && !strings.Contains(strings.ToLower(meta.Title), strings.ToLower(f.Query)) {
+		return false
+	}
+	return true
+}
+
+// ConversationMessage is a single chat message. Messages form a tree via
+// ParentID; an empty ParentID marks a root message.
 type ConversationMessage struct {
-	Role      string `json:"role"`
-	Content   string `json:"content"`
-	Timestamp int64  `json:"timestamp,omitempty"`
+	ID          string           `json:"id,omitempty"`
+	ParentID    string           `json:"parentId,omitempty"`
+	Role        string           `json:"role"`
+	Content     MessageContent   `json:"content"`
+	Attachments []ChatAttachment `json:"attachments,omitempty"`
+	Timestamp   int64            `json:"timestamp,omitempty"`
 }
 
 // ConversationStore manages conversations on disk
@@ -51,37 +129,55 @@ func (s *ConversationStore) messagesPath(id string) string {
 	return filepath.Join(s.convDir(id), "messages.json")
 }
 
-// List returns all conversations sorted by updatedAt desc
-func (s *ConversationStore) List() ([]ConversationMeta, error) {
+// List returns conversations matching the filter, pinned first, then by updatedAt desc.
+// Conversations in the trash are never listed.
+func (s *ConversationStore) List(filter ConversationFilter) ([]ConversationMeta, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	entries, err := os.ReadDir(s.baseDir)
-	if err != nil {
-		return []ConversationMeta{}, nil
-	}
-
-	var convs []ConversationMeta
-	for _, e := range entries {
-		if !e.IsDir() {
-			continue
-		}
-		meta, err := s.readMeta(e.Name())
-		if err != nil {
+	convs := []ConversationMeta{}
+	for _, meta := range s.readAllMeta() {
+		if meta.DeletedAt != 0 || !filter.matches(meta) {
 			continue
 		}
 		convs = append(convs, meta)
 	}
 
 	sort.Slice(convs, func(i, j int) bool {
+		if convs[i].Pinned != convs[j].Pinned {
+			return convs[i].Pinned
+		}
 		return convs[i].UpdatedAt > convs[j].UpdatedAt
 	})
 
 	return convs, nil
 }
 
+// ListTrash returns conversations in the trash, most recently deleted first
+func (s *ConversationStore) ListTrash() ([]ConversationMeta, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	convs := []ConversationMeta{}
+	for _, meta := range s.readAllMeta() {
+		if meta.DeletedAt != 0 {
+			convs = append(convs, meta)
+		}
+	}
+
+	sort.Slice(convs, func(i, j int) bool {
+		return convs[i].DeletedAt > convs[j].DeletedAt
+	})
+
+	return convs, nil
+}
+
 // Create creates a new conversation
 func (s *ConversationStore) Create(id, title string) (ConversationMeta, error) {
+	if id == trashConversationID {
+		return ConversationMeta{}, ErrReservedID
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -110,97 +206,291 @@ func (s *ConversationStore) Create(id, title string) (ConversationMeta, error) {
 	return meta, nil
 }
 
-// GetMessages returns all messages for a conversation
+// GetMessages returns the messages on the active branch, root first
 func (s *ConversationStore) GetMessages(id string) ([]ConversationMessage, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	return s.readMessages(id)
+	tree, meta, err := s.loadTree(id)
+	if err != nil {
+		return nil, err
+	}
+	return tree.path(activeLeaf(tree, meta)), nil
+}
+
+// GetTree returns every message of a conversation across all branches
+func (s *ConversationStore) GetTree(id string) ([]ConversationMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tree, _, err := s.loadTree(id)
+	if err != nil {
+		return nil, err
+	}
+	return tree.nodes, nil
 }
 
-// AppendMessages adds messages and updates metadata
+// AppendMessages adds messages to the end of the active branch and updates metadata
 func (s *ConversationStore) AppendMessages(id string, msgs []ConversationMessage) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	existing, _ := s.readMessages(id)
-	existing = append(existing, msgs...)
-
-	if err := s.writeMessages(id, existing); err != nil {
+	tree, meta, err := s.loadTree(id)
+	if err != nil {
 		return err
 	}
 
-	// Update meta
-	meta, err := s.readMeta(id)
+	leaf := activeLeaf(tree, meta)
+	for _, m := range msgs {
+		leaf = tree.add(leaf, m).ID
+	}
+	return s.saveTree(tree, meta, leaf)
+}
+
+// SetMessages replaces the active branch. Messages matching the existing
+// branch (by ID, or by role and content) are kept; from the first mismatch on
+// the remaining messages are stored as a new branch so the original thread
+// survives edits made by clients that only know about flat lists. An empty
+// list clears the active branch; the stored tree is kept.
+func (s *ConversationStore) SetMessages(id string, msgs []ConversationMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tree, meta, err := s.loadTree(id)
 	if err != nil {
 		return err
 	}
-	meta.UpdatedAt = time.Now().UnixMilli()
-	meta.MessageCount = len(existing)
+	if len(msgs) == 0 {
+		return s.saveTree(tree, meta, "")
+	}
 
-	// Derive title from first user message if still default
-	if meta.Title == "새 대화" || meta.Title == "" {
-		for _, m := range existing {
-			if m.Role == "user" && m.Content != "" {
-				title := m.Content
-				if len([]rune(title)) > 30 {
-					title = string([]rune(title)[:30]) + "…"
-				}
-				meta.Title = title
-				break
-			}
+	leaf := ""
+	for _, m := range msgs {
+		if child := tree.matchChild(leaf, m); child != "" {
+			leaf = child
+			continue
 		}
+		leaf = tree.add(leaf, m).ID
 	}
+	return s.saveTree(tree, meta, leaf)
+}
 
-	return s.writeMeta(meta)
+// Fork starts a new branch below messageID (or a new root when messageID is
+// empty) with the given messages and makes it active. With no messages the
+// branch simply ends at messageID, which is how "regenerate" rewinds.
+func (s *ConversationStore) Fork(id, messageID string, msgs []ConversationMessage) ([]ConversationMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tree, meta, err := s.loadTree(id)
+	if err != nil {
+		return nil, err
+	}
+	if messageID != "" && !tree.has(messageID) {
+		return nil, ErrMessageNotFound
+	}
+	if messageID == "" && len(msgs) == 0 {
+		return nil, fmt.Errorf("messages are required when forking from the root")
+	}
+
+	leaf := messageID
+	for _, m := range msgs {
+		leaf = tree.add(leaf, m).ID
+	}
+	if err := s.saveTree(tree, meta, leaf); err != nil {
+		return nil, err
+	}
+	return tree.path(leaf), nil
 }
 
-// SetMessages replaces all messages for a conversation
-func (s *ConversationStore) SetMessages(id string, msgs []ConversationMessage) error {
+// SwitchBranch makes the branch containing messageID active. If messageID is
+// not a leaf, the most recently added descendant path is followed.
+func (s *ConversationStore) SwitchBranch(id, messageID string) ([]ConversationMessage, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if err := s.writeMessages(id, msgs); err != nil {
-		return err
+	tree, meta, err := s.loadTree(id)
+	if err != nil {
+		return nil, err
 	}
+	if !tree.has(messageID) {
+		return nil, ErrMessageNotFound
+	}
+
+	leaf := tree.latestLeaf(messageID)
+	if err := s.saveTree(tree, meta, leaf); err != nil {
+		return nil, err
+	}
+	return tree.path(leaf), nil
+}
+
+// Branches lists every branch (root-to-leaf path) of a conversation
+func (s *ConversationStore) Branches(id string) ([]ConversationBranch, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tree, meta, err := s.loadTree(id)
+	if err != nil {
+		return nil, err
+	}
+	return tree.branches(activeLeaf(tree, meta)), nil
+}
+
+// Delete moves a conversation to the trash
+func (s *ConversationStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	meta, err := s.readMeta(id)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrConversationNotFound
+		}
 		return err
 	}
-	meta.UpdatedAt = time.Now().UnixMilli()
-	meta.MessageCount = len(msgs)
+	if meta.DeletedAt != 0 {
+		return nil
+	}
+	meta.DeletedAt = time.Now().UnixMilli()
+	return s.writeMeta(meta)
+}
 
-	if meta.Title == "새 대화" || meta.Title == "" {
-		for _, m := range msgs {
-			if m.Role == "user" && m.Content != "" {
-				title := m.Content
-				if len([]rune(title)) > 30 {
-					title = string([]rune(title)[:30]) + "…"
-				}
-				meta.Title = title
-				break
-			}
+// Restore moves a conversation out of the trash
+func (s *ConversationStore) Restore(id string) (ConversationMeta, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, err := s.readMeta(id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ConversationMeta{}, ErrConversationNotFound
 		}
+		return ConversationMeta{}, err
 	}
-
-	return s.writeMeta(meta)
+	meta.DeletedAt = 0
+	return meta, s.writeMeta(meta)
 }
 
-// Delete removes a conversation
-func (s *ConversationStore) Delete(id string) error {
+// DeletePermanently removes a conversation from disk
+func (s *ConversationStore) DeletePermanently(id string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	dir := s.convDir(id)
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		return fmt.Errorf("conversation not found")
+		return ErrConversationNotFound
 	}
 	return os.RemoveAll(dir)
 }
 
-// UpdateTitle updates a conversation's title
-func (s *ConversationStore) UpdateTitle(id, title string) error {
+// PurgeTrash permanently removes trashed conversations deleted more than
+// retention ago. A zero retention empties the whole trash.
+func (s *ConversationStore) PurgeTrash(retention time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().Add(-retention).UnixMilli()
+	purged := 0
+	for _, meta := range s.readAllMeta() {
+		if meta.DeletedAt == 0 || meta.DeletedAt > cutoff {
+			continue
+		}
+		if err := os.RemoveAll(s.convDir(meta.ID)); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// TrashInactive moves conversations not updated within maxAge to the trash.
+// Pinned conversations are exempt. Returns the number of conversations moved.
+func (s *ConversationStore) TrashInactive(maxAge time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-maxAge).UnixMilli()
+	moved := 0
+	for _, meta := range s.readAllMeta() {
+		if meta.DeletedAt != 0 || meta.Pinned || meta.UpdatedAt > cutoff {
+			continue
+		}
+		meta.DeletedAt = now.UnixMilli()
+		if err := s.writeMeta(meta); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+	return moved, nil
+}
+
+// Update applies the non-nil fields of upd to a conversation's metadata.
+// Trashed conversations must be restored first.
+func (s *ConversationStore) Update(id string, upd ConversationUpdate) (ConversationMeta, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, err := s.readMeta(id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ConversationMeta{}, ErrConversationNotFound
+		}
+		return ConversationMeta{}, err
+	}
+	if meta.DeletedAt != 0 {
+		return ConversationMeta{}, ErrConversationNotFound
+	}
+	if upd.Title != nil {
+		meta.Title = *upd.Title
+		meta.TitleSource = "user"
+	}
+	if upd.Tags != nil {
+		meta.Tags = normalizeTags(*upd.Tags)
+	}
+	if upd.Folder != nil {
+		meta.Folder = strings.TrimSpace(*upd.Folder)
+	}
+	if upd.Pinned != nil {
+		meta.Pinned = *upd.Pinned
+	}
+	if upd.Archived != nil {
+		meta.Archived = *upd.Archived
+	}
+	return meta, s.writeMeta(meta)
+}
+
+// GetMeta returns a conversation's metadata
+func (s *ConversationStore) GetMeta(id string) (ConversationMeta, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	meta, err := s.readMeta(id)
+	if os.IsNotExist(err) {
+		return ConversationMeta{}, ErrConversationNotFound
+	}
+	return meta, err
+}
+
+// SetInstance records which instance a conversation is being served by
+func (s *ConversationStore) SetInstance(id, instanceID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, err := s.readMeta(id)
+	if err != nil {
+		return err
+	}
+	if meta.InstanceID == instanceID {
+		return nil
+	}
+	meta.InstanceID = instanceID
+	return s.writeMeta(meta)
+}
+
+// ApplySummary stores a generated title and/or rolling summary. A generated
+// title never replaces one the user has set.
+func (s *ConversationStore) ApplySummary(id, title, summary string, messageCount int) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -208,12 +498,116 @@ func (s *ConversationStore) UpdateTitle(id, title string) error {
 	if err != nil {
 		return err
 	}
-	meta.Title = title
+	if title != "" && meta.TitleSource != "user" {
+		meta.Title = title
+		meta.TitleSource = "summary"
+	}
+	if summary != "" {
+		meta.Summary = summary
+		meta.SummaryMessageCount = messageCount
+	}
 	return s.writeMeta(meta)
 }
 
 // --- internal helpers ---
 
+// readAllMeta reads the metadata of every conversation on disk
+func (s *ConversationStore) readAllMeta() []ConversationMeta {
+	entries, err := os.ReadDir(s.baseDir)
+	if err != nil {
+		return nil
+	}
+
+	var metas []ConversationMeta
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		meta, err := s.readMeta(e.Name())
+		if err != nil {
+			continue
+		}
+		metas = append(metas, meta)
+	}
+	return metas
+}
+
+// normalizeTags trims, drops empty and de-duplicates tags
+func normalizeTags(tags []string) []string {
+	out := []string{}
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t != "" && !containsString(out, t) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// activeLeaf returns the leaf of the active branch. A conversation cleared by
+// an empty SetMessages has neither an active leaf nor messages; others
+// without an active leaf (written before branching) end at the last message.
+func activeLeaf(tree *messageTree, meta ConversationMeta) string {
+	if meta.ActiveLeafID == "" && meta.MessageCount == 0 {
+		return ""
+	}
+	return tree.resolveLeaf(meta.ActiveLeafID)
+}
+
+// loadTree reads the message tree and metadata of a conversation. Trashed
+// conversations are reported as not found until they are restored.
+func (s *ConversationStore) loadTree(id string) (*messageTree, ConversationMeta, error) {
+	meta, err := s.readMeta(id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ConversationMeta{}, ErrConversationNotFound
+		}
+		return nil, ConversationMeta{}, err
+	}
+	if meta.DeletedAt != 0 {
+		return nil, ConversationMeta{}, ErrConversationNotFound
+	}
+	msgs, _ := s.readMessages(id)
+	return newMessageTree(msgs), meta, nil
+}
+
+// saveTree writes the message tree and refreshes metadata for the active leaf
+func (s *ConversationStore) saveTree(tree *messageTree, meta ConversationMeta, leaf string) error {
+	if err := s.writeMessages(meta.ID, tree.nodes); err != nil {
+		return err
+	}
+
+	path := tree.path(leaf)
+	meta.ActiveLeafID = leaf
+	meta.UpdatedAt = time.Now().UnixMilli()
+	meta.MessageCount = len(path)
+
+	// Derive title from first user message if still default
+	if meta.Title == "새 대화" || meta.Title == "" {
+		for _, m := range path {
+			if m.Role == "user" && m.Content.String() != "" {
+				title := m.Content.String()
+				if len([]rune(title)) > 30 {
+					title = string([]rune(title)[:30]) + "…"
+				}
+				meta.Title = title
+				break
+			}
+		}
+	}
+
+	return s.writeMeta(meta)
+}
+
 func (s *ConversationStore) readMeta(id string) (ConversationMeta, error) {
 	data, err := os.ReadFile(s.metaPath(id))
 	if err != nil {
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// ConversationEvent is a chat event stored with the conversation it happened in
+type ConversationEvent struct {
+	RequestID string `json:"requestId"`
+	// AfterMessageID is the active leaf when the events were recorded, so
+	// clients can place them before the answer that followed
+	AfterMessageID string         `json:"afterMessageId,omitempty"`
+	Event          string         `json:"event"`
+	Tool           *ToolEvent     `json:"tool,omitempty"`
+	Status         *StatusEvent   `json:"status,omitempty"`
+	Thinking       *ThinkingEvent `json:"thinking,omitempty"`
+	Timestamp      int64          `json:"timestamp"`
+}
+
+func (s *ConversationStore) eventsPath(id string) string {
+	return filepath.Join(s.convDir(id), "events.json")
+}
+
+// AppendEvents stores the events of one chat request
+func (s *ConversationStore) AppendEvents(id string, events []ConversationEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, err := s.readMeta(id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrConversationNotFound
+		}
+		return err
+	}
+
+	stored := s.readEvents(id)
+	for _, e := range events {
+		e.AfterMessageID = meta.ActiveLeafID
+		stored = append(stored, e)
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.eventsPath(id), data, 0644)
+}
+
+// GetEvents returns a conversation's events, optionally only those of one request
+func (s *ConversationStore) GetEvents(id, requestID string) ([]ConversationEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, err := os.Stat(s.metaPath(id)); err != nil {
+		return nil, ErrConversationNotFound
+	}
+
+	events := s.readEvents(id)
+	if requestID == "" {
+		return events, nil
+	}
+	filtered := []ConversationEvent{}
+	for _, e := range events {
+		if e.RequestID == requestID {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered, nil
+}
+
+func (s *ConversationStore) readEvents(id string) []ConversationEvent {
+	data, err := os.ReadFile(s.eventsPath(id))
+	if err != nil {
+		return []ConversationEvent{}
+	}
+	var events []ConversationEvent
+	if err := json.Unmarshal(data, &events); err != nil {
+		return []ConversationEvent{}
+	}
+	return events
+}
+
+// collectChatEvent adds ev to the events recorded for a request. Consecutive
+// thinking deltas are merged so a long reasoning stream is stored as one entry.
+func collectChatEvent(events []ConversationEvent, ev ChatEventMessage) []ConversationEvent {
+	if n := len(events); n > 0 && ev.Event == ChatEventThinking && events[n-1].Event == ChatEventThinking {
+		merged := *events[n-1].Thinking
+		merged.Delta += ev.Thinking.Delta
+		events[n-1].Thinking = &merged
+		return events
+	}
+	return append(events, ConversationEvent{
+		RequestID: ev.RequestID,
+		Event:     ev.Event,
+		Tool:      ev.Tool,
+		Status:    ev.Status,
+		Thinking:  ev.Thinking,
+		Timestamp: ev.Timestamp,
+	})
+}
+
+// writeChatEvent writes ev to an SSE stream as a named event
+func writeChatEvent(w http.ResponseWriter, flusher http.Flusher, ev ChatEventMessage) {
+	data, _ := json.Marshal(ev)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Event, data)
+	flusher.Flush()
+}
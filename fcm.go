@@ -26,6 +26,7 @@ import (
 type FcmManager struct {
 	mu          sync.RWMutex
 	tokens      map[string]string // instanceId -> fcm token
+	seen        map[string]int64  // instanceId -> last registration (unix ms)
 	dataDir     string
 	projectID   string
 	clientEmail string
@@ -48,6 +49,7 @@ type serviceAccountKey struct {
 func NewFcmManager(dataDir, saKeyPath string) *FcmManager {
 	fm := &FcmManager{
 		tokens:  make(map[string]string),
+		seen:    make(map[string]int64),
 		dataDir: dataDir,
 	}
 	fm.loadTokens()
@@ -99,6 +101,10 @@ func (fm *FcmManager) tokensPath() string {
 	return filepath.Join(fm.dataDir, "fcm_tokens.json")
 }
 
+func (fm *FcmManager) seenPath() string {
+	return filepath.Join(fm.dataDir, "fcm_tokens_seen.json")
+}
+
 func (fm *FcmManager) loadTokens() {
 	data, err := os.ReadFile(fm.tokensPath())
 	if err != nil {
@@ -106,12 +112,46 @@ func (fm *FcmManager) loadTokens() {
 	}
 	json.Unmarshal(data, &fm.tokens)
 	log.Printf("[FCM] Loaded %d tokens", len(fm.tokens))
+
+	if data, err := os.ReadFile(fm.seenPath()); err == nil {
+		json.Unmarshal(data, &fm.seen)
+	}
+	// Tokens from before registration times were tracked count as seen now
+	now := time.Now().UnixMilli()
+	for id := range fm.tokens {
+		if _, ok := fm.seen[id]; !ok {
+			fm.seen[id] = now
+		}
+	}
 }
 
 func (fm *FcmManager) saveTokens() {
 	data, _ := json.MarshalIndent(fm.tokens, "", "  ")
 	os.MkdirAll(fm.dataDir, 0755)
 	os.WriteFile(fm.tokensPath(), data, 0644)
+
+	seen, _ := json.MarshalIndent(fm.seen, "", "  ")
+	os.WriteFile(fm.seenPath(), seen, 0644)
+}
+
+// PruneTokens drops tokens that have not been re-registered within maxAge
+func (fm *FcmManager) PruneTokens(maxAge time.Duration) int {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	cutoff := time.Now().Add(-maxAge).UnixMilli()
+	pruned := 0
+	for id := range fm.tokens {
+		if fm.seen[id] < cutoff {
+			delete(fm.tokens, id)
+			delete(fm.seen, id)
+			pruned++
+		}
+	}
+	if pruned > 0 {
+		fm.saveTokens()
+	}
+	return pruned
 }
 
 func (fm *FcmManager) RegisterToken(instanceID, token string) {
@@ -121,10 +161,48 @@ func (fm *FcmManager) RegisterToken(instanceID, token string) {
 		instanceID = "default"
 	}
 	fm.tokens[instanceID] = token
+	fm.seen[instanceID] = time.Now().UnixMilli()
 	fm.saveTokens()
 	log.Printf("[FCM] Token registered for instance: %s", instanceID)
 }
 
+// FcmTokenInfo describes a registered device, listed by /admin/devices
+type FcmTokenInfo struct {
+	InstanceID string    `json:"instanceId"`
+	Token      string    `json:"token"` // shortened
+	SeenAt     time.Time `json:"seenAt"`
+}
+
+// Tokens lists the registered devices
+func (fm *FcmManager) Tokens() []FcmTokenInfo {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+
+	tokens := make([]FcmTokenInfo, 0, len(fm.tokens))
+	for id, token := range fm.tokens {
+		if len(token) > 12 {
+			token = token[:12] + "..."
+		}
+		tokens = append(tokens, FcmTokenInfo{InstanceID: id, Token: token, SeenAt: time.UnixMilli(fm.seen[id])})
+	}
+	return tokens
+}
+
+// RevokeToken forgets the device registered for instanceID
+func (fm *FcmManager) RevokeToken(instanceID string) bool {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	if _, ok := fm.tokens[instanceID]; !ok {
+		return false
+	}
+	delete(fm.tokens, instanceID)
+	delete(fm.seen, instanceID)
+	fm.saveTokens()
+	log.Printf("[FCM] Token revoked for instance: %s", instanceID)
+	return true
+}
+
 // getAccessToken returns a valid OAuth2 access token, refreshing if needed
 func (fm *FcmManager) getAccessToken() (string, error) {
 	fm.tokenMu.Lock()
@@ -219,6 +297,31 @@ func (fm *FcmManager) SendPushTo(instanceID, title, message string) error {
 	return fm.sendToToken(token, title, message)
 }
 
+// SendPushToInstances sends a push notification to the devices registered
+// for any of instanceIDs, without falling back to other devices
+func (fm *FcmManager) SendPushToInstances(instanceIDs []string, title, message string) (int, error) {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+
+	sent := 0
+	seen := make(map[string]bool)
+	var lastErr error
+	for _, instanceID := range instanceIDs {
+		token, ok := fm.tokens[instanceID]
+		if !ok || seen[token] {
+			continue
+		}
+		seen[token] = true
+		if err := fm.sendToToken(token, title, message); err != nil {
+			log.Printf("[FCM] Send failed for %s: %v", instanceID, err)
+			lastErr = err
+			continue
+		}
+		sent++
+	}
+	return sent, lastErr
+}
+
 func (fm *FcmManager) sendToToken(token, title, message string) error {
 	accessToken, err := fm.getAccessToken()
 	if err != nil {
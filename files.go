@@ -0,0 +1,609 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StorageFiles is the storage category for stored attachments
+const StorageFiles = "files"
+
+var (
+	ErrFileNotFound = errors.New("file not found")
+	ErrFileTooLarge = errors.New("file too large")
+)
+
+// StoredFile is the metadata of a file kept in the file store
+type StoredFile struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	MimeType  string `json:"mimeType"`
+	SHA256    string `json:"sha256"`
+	Source    string `json:"source,omitempty"` // bridge ID or "app"
+	RequestID string `json:"requestId,omitempty"`
+	CreatedAt int64  `json:"createdAt"`
+	ExpiresAt int64  `json:"expiresAt,omitempty"`
+}
+
+// URL returns the path the file is served from
+func (f StoredFile) URL() string {
+	return "/api/files/" + f.ID + "/" + url.PathEscape(f.Name)
+}
+
+// FileStore keeps attachments under DataDir/files. Contents are stored once
+// per SHA-256 in blobs/; each stored file is a small JSON entry in meta/.
+type FileStore struct {
+	baseDir string
+	config  *Config
+	storage *StorageManager // optional, enforces the files quota
+
+	mu      sync.Mutex
+	uploads map[string]*fileUpload // in-progress chunked uploads
+}
+
+// fileUpload is a chunked upload being written to a temp file
+type fileUpload struct {
+	file      *os.File
+	hash      hash.Hash
+	size      int64
+	head      []byte // first bytes, for MIME sniffing
+	startedAt time.Time
+}
+
+// NewFileStore creates a file store under dataDir/files
+func NewFileStore(dataDir string, config *Config) *FileStore {
+	fs := &FileStore{
+		baseDir: filepath.Join(dataDir, "files"),
+		config:  config,
+		uploads: make(map[string]*fileUpload),
+	}
+	for _, dir := range []string{fs.blobDir(), fs.metaDir(), fs.tmpDir()} {
+		os.MkdirAll(dir, 0755)
+	}
+	return fs
+}
+
+func (fs *FileStore) blobDir() string { return filepath.Join(fs.baseDir, "blobs") }
+func (fs *FileStore) metaDir() string { return filepath.Join(fs.baseDir, "meta") }
+func (fs *FileStore) tmpDir() string  { return filepath.Join(fs.baseDir, "tmp") }
+
+func (fs *FileStore) blobPath(sum string) string {
+	return filepath.Join(fs.blobDir(), sum)
+}
+
+func (fs *FileStore) entryPath(id string) string {
+	return filepath.Join(fs.metaDir(), id+".json")
+}
+
+func (fs *FileStore) maxBytes() int64 {
+	return mbToBytes(fs.config.FileMaxMB)
+}
+
+// inlineMax is the largest file sent inline instead of by URL
+func (fs *FileStore) inlineMax() int64 {
+	return int64(fs.config.AttachmentInlineKB) * 1024
+}
+
+// absoluteURL prefixes a server-relative path with PUBLIC_URL, if set
+func (fs *FileStore) absoluteURL(path string) string {
+	return publicFileURL(fs.config.PublicURL, path)
+}
+
+// publicFileURL prefixes a /api/files/ path with base; other URLs are returned unchanged
+func publicFileURL(base, path string) string {
+	if !strings.HasPrefix(path, "/api/files/") {
+		return path
+	}
+	return strings.TrimRight(base, "/") + path
+}
+
+// Put stores the contents of r as a new file
+func (fs *FileStore) Put(name, mimeType, source, requestID string, r io.Reader) (StoredFile, error) {
+	up, err := fs.newUpload()
+	if err != nil {
+		return StoredFile{}, err
+	}
+	if max := fs.maxBytes(); max > 0 {
+		// One byte over the limit is enough for commit to reject it
+		r = io.LimitReader(r, max+1)
+	}
+	if _, err := io.Copy(up, r); err != nil {
+		up.abort()
+		return StoredFile{}, err
+	}
+	return fs.commit(up, name, mimeType, source, requestID)
+}
+
+// AppendChunk adds data to the chunked upload identified by key, starting it if needed
+func (fs *FileStore) AppendChunk(key string, data []byte) error {
+	fs.mu.Lock()
+	up, ok := fs.uploads[key]
+	if !ok {
+		var err error
+		up, err = fs.newUpload()
+		if err != nil {
+			fs.mu.Unlock()
+			return err
+		}
+		fs.uploads[key] = up
+	}
+	fs.mu.Unlock()
+
+	if _, err := up.Write(data); err != nil {
+		fs.AbortUpload(key)
+		return err
+	}
+	if max := fs.maxBytes(); max > 0 && up.size > max {
+		fs.AbortUpload(key)
+		return fmt.Errorf("%w: exceeds %s", ErrFileTooLarge, formatBytes(max))
+	}
+	return nil
+}
+
+// FinishUpload commits the chunked upload identified by key
+func (fs *FileStore) FinishUpload(key, name, mimeType, source, requestID string) (StoredFile, error) {
+	fs.mu.Lock()
+	up, ok := fs.uploads[key]
+	delete(fs.uploads, key)
+	fs.mu.Unlock()
+
+	if !ok {
+		// A file small enough to fit in its final chunk
+		var err error
+		if up, err = fs.newUpload(); err != nil {
+			return StoredFile{}, err
+		}
+	}
+	return fs.commit(up, name, mimeType, source, requestID)
+}
+
+// AbortUpload discards a chunked upload
+func (fs *FileStore) AbortUpload(key string) {
+	fs.mu.Lock()
+	up, ok := fs.uploads[key]
+	delete(fs.uploads, key)
+	fs.mu.Unlock()
+	if ok {
+		up.abort()
+	}
+}
+
+// AbortUploads discards all chunked uploads whose key starts with prefix
+func (fs *FileStore) AbortUploads(prefix string) {
+	fs.mu.Lock()
+	var aborted []*fileUpload
+	for key, up := range fs.uploads {
+		if strings.HasPrefix(key, prefix) {
+			aborted = append(aborted, up)
+			delete(fs.uploads, key)
+		}
+	}
+	fs.mu.Unlock()
+	for _, up := range aborted {
+		up.abort()
+	}
+}
+
+// Get returns a stored file's metadata; expired files are reported as missing
+func (fs *FileStore) Get(id string) (StoredFile, error) {
+	if !validFileID(id) {
+		return StoredFile{}, ErrFileNotFound
+	}
+	data, err := os.ReadFile(fs.entryPath(id))
+	if err != nil {
+		return StoredFile{}, ErrFileNotFound
+	}
+	var f StoredFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return StoredFile{}, err
+	}
+	if f.ExpiresAt != 0 && time.Now().UnixMilli() > f.ExpiresAt {
+		return StoredFile{}, ErrFileNotFound
+	}
+	return f, nil
+}
+
+// Open opens a stored file's contents
+func (fs *FileStore) Open(f StoredFile) (*os.File, error) {
+	return os.Open(fs.blobPath(f.SHA256))
+}
+
+// PurgeExpired removes expired entries, blobs no entry refers to and stale
+// temp files. Returns the number of entries removed.
+func (fs *FileStore) PurgeExpired() (int, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	entries, err := os.ReadDir(fs.metaDir())
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UnixMilli()
+	referenced := make(map[string]bool)
+	purged := 0
+	for _, e := range entries {
+		path := filepath.Join(fs.metaDir(), e.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var f StoredFile
+		if json.Unmarshal(data, &f) != nil {
+			continue
+		}
+		if f.ExpiresAt != 0 && now > f.ExpiresAt {
+			os.Remove(path)
+			purged++
+			continue
+		}
+		referenced[f.SHA256] = true
+	}
+
+	blobs, _ := os.ReadDir(fs.blobDir())
+	for _, b := range blobs {
+		if !referenced[b.Name()] {
+			os.Remove(fs.blobPath(b.Name()))
+		}
+	}
+
+	// Temp files of uploads that never finished
+	tmps, _ := os.ReadDir(fs.tmpDir())
+	for _, t := range tmps {
+		if info, err := t.Info(); err == nil && time.Since(info.ModTime()) > time.Hour {
+			os.Remove(filepath.Join(fs.tmpDir(), t.Name()))
+		}
+	}
+	return purged, nil
+}
+
+func (fs *FileStore) newUpload() (*fileUpload, error) {
+	f, err := os.CreateTemp(fs.tmpDir(), "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	return &fileUpload{file: f, hash: sha256.New(), startedAt: time.Now()}, nil
+}
+
+// commit moves a finished upload into the blob store and writes its entry
+func (fs *FileStore) commit(up *fileUpload, name, mimeType, source, requestID string) (StoredFile, error) {
+	if max := fs.maxBytes(); max > 0 && up.size > max {
+		up.abort()
+		return StoredFile{}, fmt.Errorf("%w: %s exceeds %s", ErrFileTooLarge, formatBytes(up.size), formatBytes(max))
+	}
+	if fs.storage != nil {
+		if err := fs.storage.CheckQuota(StorageFiles, up.size); err != nil {
+			up.abort()
+			return StoredFile{}, err
+		}
+	}
+	if err := up.file.Close(); err != nil {
+		os.Remove(up.file.Name())
+		return StoredFile{}, err
+	}
+
+	// Hold the lock so PurgeExpired can't remove the blob before its entry exists
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	sum := hex.EncodeToString(up.hash.Sum(nil))
+	if _, err := os.Stat(fs.blobPath(sum)); err == nil {
+		// Same content already stored
+		os.Remove(up.file.Name())
+	} else if err := os.Rename(up.file.Name(), fs.blobPath(sum)); err != nil {
+		os.Remove(up.file.Name())
+		return StoredFile{}, err
+	}
+
+	now := time.Now()
+	f := StoredFile{
+		ID:        newFileID(),
+		Name:      sanitizeFilename(name),
+		Size:      up.size,
+		MimeType:  sniffMimeType(name, mimeType, up.head),
+		SHA256:    sum,
+		Source:    source,
+		RequestID: requestID,
+		CreatedAt: now.UnixMilli(),
+	}
+	if fs.config.FileTTLHours > 0 {
+		f.ExpiresAt = now.Add(time.Duration(fs.config.FileTTLHours) * time.Hour).UnixMilli()
+	}
+
+	data, _ := json.MarshalIndent(f, "", "  ")
+	if err := os.WriteFile(fs.entryPath(f.ID), data, 0644); err != nil {
+		return StoredFile{}, err
+	}
+	log.Printf("[Files] Stored %s (%s, %d bytes, source=%s)", f.Name, f.MimeType, f.Size, f.Source)
+	return f, nil
+}
+
+// Write implements io.Writer, hashing and capping the upload as it goes
+func (up *fileUpload) Write(p []byte) (int, error) {
+	if len(up.head) < 512 {
+		n := 512 - len(up.head)
+		if n > len(p) {
+			n = len(p)
+		}
+		up.head = append(up.head, p[:n]...)
+	}
+	n, err := up.file.Write(p)
+	up.hash.Write(p[:n])
+	up.size += int64(n)
+	return n, err
+}
+
+func (up *fileUpload) abort() {
+	up.file.Close()
+	os.Remove(up.file.Name())
+}
+
+// HandleUpload POST /api/files/upload?filename=...&requestId=... - bridge pushes file bytes
+// Requires "Authorization: Bearer <BRIDGE_TOKEN>". The body is the raw file.
+func (fs *FileStore) HandleUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, err := ExtractBearerToken(r)
+	if err == nil {
+		err = ValidateBridgeToken(fs.config, token)
+	}
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	name := r.URL.Query().Get("filename")
+	if name == "" {
+		http.Error(w, "filename query param required", http.StatusBadRequest)
+		return
+	}
+	if max := fs.maxBytes(); max > 0 {
+		if r.ContentLength > max {
+			http.Error(w, ErrFileTooLarge.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, max+1)
+	}
+
+	f, err := fs.Put(name, r.Header.Get("Content-Type"), "bridge", r.URL.Query().Get("requestId"), r.Body)
+	if err != nil {
+		writeFileError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"file": f,
+		"url":  f.URL(),
+	})
+}
+
+// HandleAppUpload POST /api/files - app uploads attachments as multipart/form-data.
+// Every part with a filename is stored; the response lists them in order.
+func (fs *FileStore) HandleAppUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "multipart/form-data required", http.StatusBadRequest)
+		return
+	}
+
+	files := []StoredFile{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, "Invalid multipart body", http.StatusBadRequest)
+			return
+		}
+		if part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		f, err := fs.Put(part.FileName(), part.Header.Get("Content-Type"), "app", "", part)
+		part.Close()
+		if err != nil {
+			writeFileError(w, err)
+			return
+		}
+		files = append(files, f)
+	}
+	if len(files) == 0 {
+		http.Error(w, "No files in request", http.StatusBadRequest)
+		return
+	}
+
+	urls := make([]string, len(files))
+	for i, f := range files {
+		urls[i] = f.URL()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"files": files,
+		"urls":  urls,
+	})
+}
+
+// ResolveAttachments fills in metadata for attachments referenced by FileID.
+// Files up to inlineMax bytes are inlined; larger ones get an absolute URL
+// based on PUBLIC_URL (or a server-relative one if that is not set).
+func (fs *FileStore) ResolveAttachments(messages []ChatMessage) error {
+	inlineMax := fs.inlineMax()
+
+	for i := range messages {
+		for j := range messages[i].Attachments {
+			att := &messages[i].Attachments[j]
+			f, err := fs.Get(att.FileID)
+			if err != nil {
+				return fmt.Errorf("attachment %s: %w", att.FileID, err)
+			}
+
+			att.Name = f.Name
+			att.MimeType = f.MimeType
+			att.Size = f.Size
+			att.URL = fs.absoluteURL(f.URL())
+			att.Data = nil
+			if f.Size <= inlineMax {
+				file, err := fs.Open(f)
+				if err != nil {
+					return fmt.Errorf("attachment %s: %w", att.FileID, err)
+				}
+				att.Data, err = io.ReadAll(file)
+				file.Close()
+				if err != nil {
+					return fmt.Errorf("attachment %s: %w", att.FileID, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// HandleServe GET /api/files/{id}/{name} - serves a stored file (Range supported)
+func (fs *FileStore) HandleServe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/files/"), "/", 2)
+
+	f, err := fs.Get(parts[0])
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	file, err := fs.Open(f)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	// Uploads are unauthenticated: never let the browser render them as a page
+	mimeType, disposition := f.MimeType, "attachment"
+	if activeMimeType(mimeType) {
+		mimeType = "application/octet-stream"
+	} else if inlineMimeType(mimeType) {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": f.Name}))
+	w.Header().Set("ETag", strconv.Quote(f.SHA256))
+	if f.ExpiresAt != 0 {
+		w.Header().Set("Expires", time.UnixMilli(f.ExpiresAt).UTC().Format(http.TimeFormat))
+	}
+	http.ServeContent(w, r, f.Name, time.UnixMilli(f.CreatedAt), file)
+}
+
+// writeFileError maps file store errors to HTTP status codes
+func writeFileError(w http.ResponseWriter, err error) {
+	var maxErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, ErrFileTooLarge), errors.As(err, &maxErr):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, ErrQuotaExceeded):
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// sniffMimeType prefers the type sniffed from the content. Only when sniffing
+// finds nothing specific (binary, plain text or a zip container) does the
+// declared type, then the file extension, decide. Types a browser would run
+// as a document are stored as application/octet-stream.
+func sniffMimeType(name, declared string, head []byte) string {
+	t := http.DetectContentType(head)
+	base, _, _ := mime.ParseMediaType(t)
+	if base == "application/octet-stream" || base == "text/plain" || base == "application/zip" {
+		if declared != "" && declared != "application/octet-stream" {
+			t = declared
+		} else if ext := mime.TypeByExtension(filepath.Ext(name)); ext != "" {
+			t = ext
+		}
+	}
+	if activeMimeType(t) {
+		return "application/octet-stream"
+	}
+	return t
+}
+
+// activeMimeType reports whether a browser may execute content of type t
+// (html, svg and other xml, javascript)
+func activeMimeType(t string) bool {
+	base, _, err := mime.ParseMediaType(t)
+	if err != nil {
+		base = strings.ToLower(strings.TrimSpace(t))
+	}
+	return strings.Contains(base, "html") || strings.Contains(base, "javascript") || strings.Contains(base, "ecmascript") ||
+		base == "text/xml" || base == "application/xml" || strings.HasSuffix(base, "+xml")
+}
+
+// inlineMimeType reports whether a file of type t may be shown in the browser
+// rather than downloaded
+func inlineMimeType(t string) bool {
+	if activeMimeType(t) {
+		return false
+	}
+	return strings.HasPrefix(t, "image/") || strings.HasPrefix(t, "audio/") || strings.HasPrefix(t, "application/pdf")
+}
+
+// sanitizeFilename strips directories and control characters from a filename
+func sanitizeFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	return name
+}
+
+// newFileID returns an unguessable file ID (the URL is the capability)
+func newFileID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func validFileID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
@@ -4,4 +4,4 @@ go 1.23.6
 
 require golang.org/x/net v0.35.0
 
-require github.com/gorilla/websocket v1.5.3 // indirect
+require github.com/gorilla/websocket v1.5.3
@@ -9,6 +9,11 @@ import (
 )
 
 func main() {
+	// `voicechat-server admin ...` manages a running server instead of starting one
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		os.Exit(runAdmin(os.Args[2:]))
+	}
+
 	// Load configuration
 	config := LoadConfig()
 
@@ -21,9 +21,17 @@ type NotificationHub struct {
 }
 
 type NotificationConn struct {
-	conn       *websocket.Conn
-	instanceID string
-	send       chan []byte
+	conn        *websocket.Conn
+	instanceID  string
+	send        chan []byte
+	connectedAt time.Time
+}
+
+// NotificationClientInfo describes a connected app, listed by /admin/notifications
+type NotificationClientInfo struct {
+	InstanceID  string    `json:"instanceId"`
+	RemoteAddr  string    `json:"remoteAddr"`
+	ConnectedAt time.Time `json:"connectedAt"`
 }
 
 type NotificationMessage struct {
@@ -90,6 +98,52 @@ func (h *NotificationHub) SendTo(instanceID, notifType, title, message string) {
 	}
 }
 
+// SendToInstances sends a notification to clients connected with any of
+// instanceIDs and returns how many it reached
+func (h *NotificationHub) SendToInstances(instanceIDs []string, notifType, title, message string) int {
+	msg := NotificationMessage{
+		Type:             "notification",
+		ID:               time.Now().Format("20060102150405.000"),
+		NotificationType: notifType,
+		Title:            title,
+		Message:          message,
+		Timestamp:        time.Now().UnixMilli(),
+	}
+	data, _ := json.Marshal(msg)
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	sent := 0
+	for client := range h.clients {
+		if !containsString(instanceIDs, client.instanceID) {
+			continue
+		}
+		select {
+		case client.send <- data:
+			sent++
+		default:
+		}
+	}
+	return sent
+}
+
+// Clients lists the connected clients
+func (h *NotificationHub) Clients() []NotificationClientInfo {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	clients := make([]NotificationClientInfo, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, NotificationClientInfo{
+			InstanceID:  client.instanceID,
+			RemoteAddr:  client.conn.RemoteAddr().String(),
+			ConnectedAt: client.connectedAt,
+		})
+	}
+	return clients
+}
+
 // ClientCount returns the number of connected clients
 func (h *NotificationHub) ClientCount() int {
 	h.mu.RLock()
@@ -120,8 +174,9 @@ func (h *NotificationHub) HandleWebSocket(w http.ResponseWriter, r *http.Request
 	}
 
 	client := &NotificationConn{
-		conn: conn,
-		send: make(chan []byte, 64),
+		conn:        conn,
+		send:        make(chan []byte, 64),
+		connectedAt: time.Now(),
 	}
 
 	h.addClient(client)
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"compress/flate"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net"
+	"sync"
 )
 
 // Message types
@@ -15,6 +20,57 @@ const (
 	MsgTypeChatResponse = "chat_response"
 	MsgTypeChatError    = "chat_error"
 	MsgTypeFileResponse = "file_response"
+	MsgTypeFileChunk    = "file_chunk"
+	MsgTypeFileStored   = "file_stored"
+	MsgTypeChatEvent    = "chat_event"
+	MsgTypeWakeRequest  = "wake_request"
+	MsgTypeWakeResult   = "wake_result"
+	MsgTypeChatCredit   = "chat_credit"
+	MsgTypeChatCancel   = "chat_cancel"
+	MsgTypeRegistered   = "registered"
+	MsgTypeStreamOpen   = "stream_open"
+	MsgTypeBridgeInfo   = "bridge_info"
+	MsgTypeNotify       = "notify"
+	MsgTypeNotifyResult = "notify_result"
+	MsgTypeRPCRequest   = "rpc_request"
+	MsgTypeRPCResponse  = "rpc_response"
+)
+
+// Bridge capabilities declared at registration
+const (
+	CapabilityWaker        = "waker"         // can send Wake-on-LAN magic packets on its LAN
+	CapabilityBinaryFrames = "binary_frames" // switches to typed frames after the registered reply
+	CapabilityDeflate      = "deflate"       // may deflate large typed frames (needs binary_frames)
+	CapabilityFlowControl  = "flow_control"  // honours chat_request.window and chat_credit
+)
+
+// Typed frames, used once a bridge with CapabilityBinaryFrames is registered:
+// a 4-byte big-endian length (type byte included), the type byte, then the
+// payload. Binary payloads start with a 4-byte stream ID and a flags byte;
+// stream IDs are chosen by the sender, scoped to its direction, and
+// announced with stream_open before the first chunk.
+const (
+	FrameJSON   byte = 0x01
+	FrameBinary byte = 0x02
+	// FrameCompressed is set on the type byte of a frame whose payload is
+	// deflate-compressed, once the registered reply confirmed "deflate"
+	FrameCompressed byte = 0x80
+
+	BinaryFlagEnd byte = 0x01 // last chunk of the stream
+)
+
+// Stream kinds announced by StreamOpenMessage
+const (
+	StreamKindFile       = "file"       // bridge -> server, stored like file_chunk uploads
+	StreamKindAttachment = "attachment" // server -> bridge, data of a chat attachment
+)
+
+// Chat event kinds carried by ChatEventMessage
+const (
+	ChatEventToolStart = "tool_start"
+	ChatEventToolEnd   = "tool_end"
+	ChatEventStatus    = "status"
+	ChatEventThinking  = "thinking"
 )
 
 // Base message structure
@@ -24,36 +80,136 @@ type Message struct {
 
 // Register message from bridge
 type RegisterMessage struct {
+	Type  string   `json:"type"`
+	Name  string   `json:"name"`
+	Token string   `json:"token"`
+	Group string   `json:"group,omitempty"` // routing group, targeted as "group:<name>"
+	Tags  []string `json:"tags,omitempty"`  // extra groups this bridge serves
+	// Capabilities lists optional features, e.g. "waker"
+	Capabilities []string `json:"capabilities,omitempty"`
+	// MAC is the bridge host's own MAC address, remembered so a waker can wake it later
+	MAC string `json:"mac,omitempty"`
+	// Metadata shown in /api/instances
+	Version string `json:"version,omitempty"`
+	OS      string `json:"os,omitempty"`
+	// Models and agents the app may pick per chat; bridge_info updates them
+	Models []string `json:"models,omitempty"`
+	Agents []string `json:"agents,omitempty"`
+}
+
+// NotifyMessage is a notification a bridge sends to the apps using it
+// (those connected with its ID, name, group or, if it serves them, "default")
+type NotifyMessage struct {
 	Type  string `json:"type"`
-	Name  string `json:"name"`
-	Token string `json:"token"`
+	ID    string `json:"id,omitempty"` // if set, answered with notify_result
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	Level string `json:"level,omitempty"` // info (default), success, warning, error
+	// Push controls FCM: "" sends it only when no app is connected,
+	// "always" in any case, "never" not at all
+	Push string `json:"push,omitempty"`
+}
+
+// NotifyResultMessage reports how a notify message was delivered
+type NotifyResultMessage struct {
+	Type    string `json:"type"`
+	ID      string `json:"id"`
+	Sent    int    `json:"sent"`    // apps reached over WebSocket
+	FCMSent int    `json:"fcmSent"` // devices reached through FCM
+	Error   string `json:"error,omitempty"`
+}
+
+// RPCRequestMessage asks a bridge to run a named action, e.g.
+// "restart_gateway", "pc_status", "lock_screen" or "shortcut". Bridges decide
+// which methods they allow.
+type RPCRequestMessage struct {
+	Type   string          `json:"type"`
+	ID     string          `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
 }
 
-// Heartbeat message
+// RPCResponseMessage answers an rpc_request with a result or an error.
+// Code classifies errors: "not_allowed", "not_found" or anything else.
+type RPCResponseMessage struct {
+	Type   string          `json:"type"`
+	ID     string          `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	Code   string          `json:"code,omitempty"`
+}
+
+// BridgeInfoMessage replaces the models and agents a bridge listed at
+// registration, e.g. after its gateway config changed
+type BridgeInfoMessage struct {
+	Type   string   `json:"type"`
+	Models []string `json:"models"`
+	Agents []string `json:"agents"`
+}
+
+// Heartbeat message. Bridges send one at least every 30s and the server
+// answers it. The server also probes with Ping set; bridges that echo it
+// back as Pong (without expecting an answer) get their round-trip time
+// measured.
 type HeartbeatMessage struct {
 	Type string `json:"type"`
+	Ping int64  `json:"ping,omitempty"`
+	Pong int64  `json:"pong,omitempty"`
 }
 
 // Chat message structure
 type ChatMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role        string           `json:"role"`
+	Content     MessageContent   `json:"content"` // string or array of content parts
+	Attachments []ChatAttachment `json:"attachments,omitempty"`
+}
+
+// ChatAttachment is a file attached to a chat message. The app only sends
+// FileID (from POST /api/files); the server fills in the rest before the
+// request reaches the bridge. Small files are inlined in Data, larger ones
+// must be fetched from URL.
+type ChatAttachment struct {
+	FileID   string `json:"fileId"`
+	Name     string `json:"name,omitempty"`
+	MimeType string `json:"mimeType,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Data     []byte `json:"data,omitempty"` // base64 in JSON
+	// StreamID replaces Data for bridges using binary frames
+	StreamID uint32 `json:"streamId,omitempty"`
 }
 
 // Chat request from server to bridge
 type ChatRequestMessage struct {
-	Type      string        `json:"type"`
-	RequestID string        `json:"requestId"`
-	Messages  []ChatMessage `json:"messages"`
-	User      string        `json:"user,omitempty"`
+	Type      string              `json:"type"`
+	RequestID string              `json:"requestId"`
+	Messages  []BridgeChatMessage `json:"messages"`
+	User      string              `json:"user,omitempty"`
+	// Agent and Model select one of the bridge's advertised agents/models
+	// (empty = the bridge's default)
+	Agent string `json:"agent,omitempty"`
+	Model string `json:"model,omitempty"`
+	// Window is how many chat_response, chat_event and file_response frames
+	// the bridge may send before waiting for chat_credit (0 = unlimited).
+	// Only set for bridges declaring CapabilityFlowControl.
+	Window int `json:"window,omitempty"`
 }
 
 // Chat response from bridge to server
 type ChatResponseMessage struct {
-	Type      string `json:"type"`
-	RequestID string `json:"requestId"`
-	Delta     string `json:"delta"`
-	Done      bool   `json:"done"`
+	Type      string     `json:"type"`
+	RequestID string     `json:"requestId"`
+	Delta     string     `json:"delta"`
+	Done      bool       `json:"done"`
+	Usage     *ChatUsage `json:"usage,omitempty"` // token usage, on the Done frame
+}
+
+// ChatUsage reports the tokens a chat request consumed
+type ChatUsage struct {
+	Model            string `json:"model,omitempty"`
+	PromptTokens     int    `json:"promptTokens"`
+	CompletionTokens int    `json:"completionTokens"`
+	TotalTokens      int    `json:"totalTokens,omitempty"`
 }
 
 // Chat error message
@@ -63,6 +219,76 @@ type ChatErrorMessage struct {
 	Error     string `json:"error"`
 }
 
+// ChatEventMessage reports progress of a chat request besides answer text:
+// tools being run, status lines and model thinking. Exactly one payload
+// matching Event is set.
+type ChatEventMessage struct {
+	Type      string         `json:"type"`
+	RequestID string         `json:"requestId"`
+	Event     string         `json:"event"`
+	Tool      *ToolEvent     `json:"tool,omitempty"`     // tool_start, tool_end
+	Status    *StatusEvent   `json:"status,omitempty"`   // status
+	Thinking  *ThinkingEvent `json:"thinking,omitempty"` // thinking
+	Timestamp int64          `json:"timestamp,omitempty"`
+}
+
+// ToolEvent describes a tool invocation
+type ToolEvent struct {
+	CallID     string          `json:"callId,omitempty"`
+	Name       string          `json:"name"`
+	Input      json.RawMessage `json:"input,omitempty"`  // tool_start
+	Output     string          `json:"output,omitempty"` // tool_end, may be truncated by the bridge
+	Error      string          `json:"error,omitempty"`  // tool_end
+	DurationMs int64           `json:"durationMs,omitempty"`
+}
+
+// StatusEvent is a short human-readable progress line (e.g. "searching the web…")
+type StatusEvent struct {
+	Text string `json:"text"`
+}
+
+// ThinkingEvent carries a piece of the model's reasoning text
+type ThinkingEvent struct {
+	Delta string `json:"delta"`
+}
+
+// Validate checks that the payload matching Event is present
+func (m ChatEventMessage) Validate() error {
+	switch m.Event {
+	case ChatEventToolStart, ChatEventToolEnd:
+		if m.Tool == nil || m.Tool.Name == "" {
+			return fmt.Errorf("%s: tool.name is required", m.Event)
+		}
+	case ChatEventStatus:
+		if m.Status == nil {
+			return fmt.Errorf("status: status payload is required")
+		}
+	case ChatEventThinking:
+		if m.Thinking == nil {
+			return fmt.Errorf("thinking: thinking payload is required")
+		}
+	default:
+		return fmt.Errorf("unknown chat event '%s'", m.Event)
+	}
+	return nil
+}
+
+// ChatCreditMessage lets the bridge send Credit more frames for a request,
+// once the app has taken the ones before
+type ChatCreditMessage struct {
+	Type      string `json:"type"`
+	RequestID string `json:"requestId"`
+	Credit    int    `json:"credit"`
+}
+
+// ChatCancelMessage tells the bridge to stop working on a request because
+// nobody is reading the answer any more
+type ChatCancelMessage struct {
+	Type      string `json:"type"`
+	RequestID string `json:"requestId"`
+	Reason    string `json:"reason,omitempty"`
+}
+
 // FileResponseMessage carries a file attachment from bridge to app
 type FileResponseMessage struct {
 	Type      string `json:"type"`
@@ -71,6 +297,72 @@ type FileResponseMessage struct {
 	URL       string `json:"url"`
 	Size      int64  `json:"size"`
 	MimeType  string `json:"mimeType,omitempty"`
+	// stored is set on file_responses the server builds for a finished
+	// upload; the bridge never sent them, so they return no chat_credit
+	stored bool
+}
+
+// FileChunkMessage pushes file bytes from bridge to server for storage.
+// Chunks with the same FileID are appended in order; the chunk with Done set
+// (which may also carry data) completes the file, after which the server
+// replies with FileStoredMessage and forwards a file_response to the app.
+type FileChunkMessage struct {
+	Type      string `json:"type"`
+	RequestID string `json:"requestId,omitempty"`
+	FileID    string `json:"fileId"` // chosen by the bridge, unique per connection
+	Filename  string `json:"filename,omitempty"`
+	MimeType  string `json:"mimeType,omitempty"`
+	Data      []byte `json:"data,omitempty"` // base64 in JSON
+	Done      bool   `json:"done"`
+}
+
+// FileStoredMessage tells the bridge where a pushed file can be fetched
+type FileStoredMessage struct {
+	Type     string `json:"type"`
+	FileID   string `json:"fileId"`
+	URL      string `json:"url,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	MimeType string `json:"mimeType,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// RegisteredMessage confirms a registration that asked for binary frames;
+// every later frame in both directions is a typed frame
+type RegisteredMessage struct {
+	Type        string `json:"type"`
+	ID          string `json:"id"`
+	Framing     string `json:"framing"`               // "typed"
+	Compression string `json:"compression,omitempty"` // "deflate" if both sides may compress frames
+}
+
+// StreamOpenMessage announces a stream of binary chunks. A bridge may have 16
+// file streams open at once; one idle for 5 minutes is dropped. Either case
+// is answered with a file_stored error.
+type StreamOpenMessage struct {
+	Type      string `json:"type"`
+	StreamID  uint32 `json:"streamId"`
+	Kind      string `json:"kind"` // StreamKindFile or StreamKindAttachment
+	RequestID string `json:"requestId,omitempty"`
+	FileID    string `json:"fileId"`
+	Filename  string `json:"filename,omitempty"`
+	MimeType  string `json:"mimeType,omitempty"`
+	Size      int64  `json:"size,omitempty"` // total bytes, if known
+}
+
+// WakeRequestMessage asks a waker bridge to send a magic packet
+type WakeRequestMessage struct {
+	Type      string `json:"type"`
+	RequestID string `json:"requestId"`
+	Target    string `json:"target"` // name of the bridge being woken
+	MAC       string `json:"mac"`
+}
+
+// WakeResultMessage reports whether the waker sent the magic packet
+type WakeResultMessage struct {
+	Type      string `json:"type"`
+	RequestID string `json:"requestId"`
+	OK        bool   `json:"ok"`
+	Error     string `json:"error,omitempty"`
 }
 
 // SendMessage sends a JSON message over TCP with 4-byte length header
@@ -91,16 +383,103 @@ func SendMessage(conn net.Conn, msg interface{}) error {
 	return err
 }
 
-// ReadMessage reads a JSON message from TCP with 4-byte length header
-func ReadMessage(conn net.Conn) ([]byte, error) {
+// ErrFrameTooLarge is returned for frames longer than the reader allows
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// ReadMessage reads a JSON message from TCP with 4-byte length header.
+// Frames longer than maxSize bytes are rejected before anything is allocated.
+func ReadMessage(conn io.Reader, maxSize int) ([]byte, error) {
 	// Read 4-byte length header
 	var length uint32
 	if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
 		return nil, err
 	}
+	if int64(length) > int64(maxSize) {
+		return nil, fmt.Errorf("%w: %d bytes (max %d)", ErrFrameTooLarge, length, maxSize)
+	}
 
 	// Read JSON data
 	data := make([]byte, length)
 	_, err := io.ReadFull(conn, data)
 	return data, err
-}
\ No newline at end of file
+}
+
+// WriteFrame writes a typed frame in a single write
+func WriteFrame(w io.Writer, frameType byte, payload []byte) error {
+	buf := make([]byte, 5+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(1+len(payload)))
+	buf[4] = frameType
+	copy(buf[5:], payload)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadFrame reads a typed frame whose payload is at most maxSize bytes
+func ReadFrame(r io.Reader, maxSize int) (byte, []byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return 0, nil, err
+	}
+	if length == 0 {
+		return 0, nil, fmt.Errorf("empty frame")
+	}
+	if int64(length)-1 > int64(maxSize) {
+		return 0, nil, fmt.Errorf("%w: %d bytes (max %d)", ErrFrameTooLarge, length-1, maxSize)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+	return data[0], data[1:], nil
+}
+
+// EncodeBinaryChunk builds the payload of a binary frame
+func EncodeBinaryChunk(streamID uint32, end bool, data []byte) []byte {
+	payload := make([]byte, 5+len(data))
+	binary.BigEndian.PutUint32(payload, streamID)
+	if end {
+		payload[4] = BinaryFlagEnd
+	}
+	copy(payload[5:], data)
+	return payload
+}
+
+// DecodeBinaryChunk splits the payload of a binary frame
+func DecodeBinaryChunk(payload []byte) (streamID uint32, end bool, data []byte, err error) {
+	if len(payload) < 5 {
+		return 0, false, nil, fmt.Errorf("binary frame too short: %d bytes", len(payload))
+	}
+	return binary.BigEndian.Uint32(payload), payload[4]&BinaryFlagEnd != 0, payload[5:], nil
+}
+
+var flateWriters = sync.Pool{New: func() interface{} {
+	w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+	return w
+}}
+
+// DeflatePayload compresses a frame payload
+func DeflatePayload(data []byte) []byte {
+	var buf bytes.Buffer
+	w := flateWriters.Get().(*flate.Writer)
+	w.Reset(&buf)
+	w.Write(data)
+	w.Close()
+	flateWriters.Put(w)
+	return buf.Bytes()
+}
+
+// InflatePayload decompresses a frame payload that may expand to at most
+// maxSize bytes
+func InflatePayload(data []byte, maxSize int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	out, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > maxSize {
+		return nil, fmt.Errorf("%w: inflates beyond %d bytes", ErrFrameTooLarge, maxSize)
+	}
+	return out, nil
+}
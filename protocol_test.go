@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// legacyFrame builds a 4-byte length + JSON frame as SendMessage writes it
+func legacyFrame(payload []byte) []byte {
+	buf := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(len(payload)))
+	copy(buf[4:], payload)
+	return buf
+}
+
+// typedFrame builds a frame as WriteFrame writes it
+func typedFrame(frameType byte, payload []byte) []byte {
+	var buf bytes.Buffer
+	WriteFrame(&buf, frameType, payload)
+	return buf.Bytes()
+}
+
+// lengthPrefix is a bare 4-byte length header claiming n bytes
+func lengthPrefix(n uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, n)
+}
+
+func FuzzReadMessage(f *testing.F) {
+	f.Add(legacyFrame([]byte(`{"type":"heartbeat","ping":1}`)), uint16(1024))
+	f.Add(legacyFrame([]byte(`{"type":"register","token":"t","name":"n"}`)), uint16(16*1024))
+	f.Add(legacyFrame(nil), uint16(0))
+	f.Add(legacyFrame(bytes.Repeat([]byte("x"), 65)), uint16(64))
+	f.Add(lengthPrefix(0xFFFFFFFF), uint16(1024))
+	f.Add(lengthPrefix(1<<31), uint16(65535))
+	f.Add(append(lengthPrefix(10), "short"...), uint16(1024))
+	f.Add([]byte{0x00, 0x01}, uint16(1024))
+
+	f.Fuzz(func(t *testing.T, data []byte, maxSize uint16) {
+		msg, err := ReadMessage(bytes.NewReader(data), int(maxSize))
+		if len(data) < 4 {
+			if err == nil {
+				t.Fatalf("read a message from a %d-byte input", len(data))
+			}
+			return
+		}
+
+		length := binary.BigEndian.Uint32(data)
+		if int64(length) > int64(maxSize) {
+			if !errors.Is(err, ErrFrameTooLarge) {
+				t.Fatalf("length %d over max %d: got err %v, want ErrFrameTooLarge", length, maxSize, err)
+			}
+			return
+		}
+		if err != nil {
+			if int64(len(data)-4) >= int64(length) {
+				t.Fatalf("complete %d-byte message rejected: %v", length, err)
+			}
+			return
+		}
+		if len(msg) > int(maxSize) {
+			t.Fatalf("message of %d bytes exceeds max %d", len(msg), maxSize)
+		}
+		if !bytes.Equal(msg, data[4:4+length]) {
+			t.Fatalf("message does not match the input")
+		}
+	})
+}
+
+func FuzzReadFrame(f *testing.F) {
+	f.Add(typedFrame(FrameJSON, []byte(`{"type":"chat_response","requestId":"r","delta":"hi"}`)), uint16(1024))
+	f.Add(typedFrame(FrameBinary, EncodeBinaryChunk(7, true, []byte("data"))), uint16(1024))
+	f.Add(typedFrame(FrameJSON|FrameCompressed, DeflatePayload([]byte(`{"type":"heartbeat"}`))), uint16(1024))
+	f.Add(typedFrame(FrameJSON, nil), uint16(0))
+	f.Add(typedFrame(FrameBinary, bytes.Repeat([]byte{0xAB}, 65)), uint16(64))
+	f.Add(lengthPrefix(0), uint16(1024))
+	f.Add(lengthPrefix(0xFFFFFFFF), uint16(1024))
+	f.Add(append(lengthPrefix(1<<20), FrameJSON), uint16(65535))
+
+	f.Fuzz(func(t *testing.T, data []byte, maxSize uint16) {
+		frameType, payload, err := ReadFrame(bytes.NewReader(data), int(maxSize))
+		if len(data) < 4 {
+			if err == nil {
+				t.Fatalf("read a frame from a %d-byte input", len(data))
+			}
+			return
+		}
+
+		length := binary.BigEndian.Uint32(data)
+		switch {
+		case length == 0:
+			if err == nil {
+				t.Fatalf("empty frame accepted")
+			}
+			return
+		case int64(length)-1 > int64(maxSize):
+			if !errors.Is(err, ErrFrameTooLarge) {
+				t.Fatalf("payload %d over max %d: got err %v, want ErrFrameTooLarge", length-1, maxSize, err)
+			}
+			return
+		}
+		if err != nil {
+			if int64(len(data)-4) >= int64(length) {
+				t.Fatalf("complete %d-byte frame rejected: %v", length, err)
+			}
+			return
+		}
+		if len(payload) > int(maxSize) {
+			t.Fatalf("payload of %d bytes exceeds max %d", len(payload), maxSize)
+		}
+		if got := typedFrame(frameType, payload); !bytes.Equal(got, data[:4+length]) {
+			t.Fatalf("re-encoded frame does not match the input")
+		}
+	})
+}
+
+func FuzzDecodeBinaryChunk(f *testing.F) {
+	f.Add(EncodeBinaryChunk(1, false, []byte("hello")))
+	f.Add(EncodeBinaryChunk(0xFFFFFFFF, true, nil))
+	f.Add(EncodeBinaryChunk(42, true, bytes.Repeat([]byte{0}, 4096)))
+	f.Add([]byte{0x00, 0x00, 0x00, 0x01})
+	f.Add([]byte{})
+	f.Add([]byte{0x00, 0x00, 0x00, 0x01, 0xFE})
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		streamID, end, data, err := DecodeBinaryChunk(payload)
+		if len(payload) < 5 {
+			if err == nil {
+				t.Fatalf("decoded a %d-byte payload", len(payload))
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("decode %d-byte payload: %v", len(payload), err)
+		}
+		if len(data) != len(payload)-5 {
+			t.Fatalf("data is %d bytes, want %d", len(data), len(payload)-5)
+		}
+
+		id2, end2, data2, err := DecodeBinaryChunk(EncodeBinaryChunk(streamID, end, data))
+		if err != nil || id2 != streamID || end2 != end || !bytes.Equal(data2, data) {
+			t.Fatalf("round trip mismatch: (%d, %v, %d bytes, %v)", id2, end2, len(data2), err)
+		}
+	})
+}
+
+func FuzzInflatePayload(f *testing.F) {
+	f.Add(DeflatePayload([]byte(`{"type":"chat_response","delta":"hello"}`)), uint16(1024))
+	f.Add(DeflatePayload(nil), uint16(0))
+	f.Add(DeflatePayload(bytes.Repeat([]byte("a"), 65)), uint16(64))
+	// Decompression bomb: 1 MiB of zeros against a small limit
+	f.Add(DeflatePayload(make([]byte, 1<<20)), uint16(4096))
+	f.Add([]byte("not deflate"), uint16(1024))
+	f.Add([]byte{}, uint16(1024))
+
+	f.Fuzz(func(t *testing.T, data []byte, maxSize uint16) {
+		out, err := InflatePayload(data, int(maxSize))
+		if err == nil && len(out) > int(maxSize) {
+			t.Fatalf("inflated %d bytes, max %d", len(out), maxSize)
+		}
+
+		// data as plain text must survive a round trip, and only within the limit
+		compressed := DeflatePayload(data)
+		got, err := InflatePayload(compressed, len(data))
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("round trip of %d bytes failed: %v", len(data), err)
+		}
+		if len(data) > 0 {
+			if _, err := InflatePayload(compressed, len(data)-1); !errors.Is(err, ErrFrameTooLarge) {
+				t.Fatalf("%d bytes inflated under a limit of %d: err %v", len(data), len(data)-1, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Queued request states
+const (
+	QueueStatusPending   = "pending"
+	QueueStatusDelivered = "delivered"
+	QueueStatusFailed    = "failed"
+	QueueStatusExpired   = "expired"
+)
+
+var ErrQueuedRequestNotFound = errors.New("queued request not found")
+
+// QueuedRequest is a chat request accepted while its bridge was offline
+type QueuedRequest struct {
+	ID             string        `json:"id"`
+	Target         string        `json:"target"`     // bridge name, "group:<name>" or "default"
+	InstanceID     string        `json:"instanceId"` // as sent by the app, used for notifications
+	ConversationID string        `json:"conversationId,omitempty"`
+	DeviceID       string        `json:"deviceId,omitempty"`
+	Messages       []ChatMessage `json:"messages"`
+	Agent          string        `json:"agent,omitempty"`
+	Model          string        `json:"model,omitempty"`
+	Status         string        `json:"status"`
+	Attempts       int           `json:"attempts"`
+	Answer         string        `json:"answer,omitempty"`
+	Error          string        `json:"error,omitempty"`
+	CreatedAt      int64         `json:"createdAt"`
+	ExpiresAt      int64         `json:"expiresAt,omitempty"`
+	DeliveredAt    int64         `json:"deliveredAt,omitempty"`
+}
+
+// OfflineQueue persists chat requests for disconnected bridges under
+// DataDir/queue and runs them when a matching bridge registers.
+type OfflineQueue struct {
+	dir           string
+	config        *Config
+	bridges       *BridgeManager
+	relay         *RelayManager
+	conversations *ConversationStore
+	usage         *UsageLedger
+	notify        func(instanceID, title, body string)
+
+	mu   sync.Mutex
+	kick chan struct{}
+}
+
+// NewOfflineQueue creates the queue and starts its delivery worker
+func NewOfflineQueue(dataDir string, config *Config, bridges *BridgeManager, relay *RelayManager, conversations *ConversationStore, usage *UsageLedger, notify func(instanceID, title, body string)) *OfflineQueue {
+	dir := filepath.Join(dataDir, "queue")
+	os.MkdirAll(dir, 0755)
+
+	q := &OfflineQueue{
+		dir:           dir,
+		config:        config,
+		bridges:       bridges,
+		relay:         relay,
+		conversations: conversations,
+		usage:         usage,
+		notify:        notify,
+		kick:          make(chan struct{}, 1),
+	}
+	go q.worker()
+	return q
+}
+
+// Enqueue stores a chat request until target's bridge is back
+func (q *OfflineQueue) Enqueue(chatReq *ChatRequest, target, deviceID string) (QueuedRequest, error) {
+	now := time.Now()
+	req := QueuedRequest{
+		ID:             generateQueueID(),
+		Target:         target,
+		InstanceID:     chatReq.InstanceID,
+		ConversationID: chatReq.ConversationID,
+		DeviceID:       deviceID,
+		Messages:       chatReq.Messages,
+		Agent:          chatReq.Agent,
+		Model:          chatReq.Model,
+		Status:         QueueStatusPending,
+		CreatedAt:      now.UnixMilli(),
+	}
+	if q.config.QueueTTLHours > 0 {
+		req.ExpiresAt = now.Add(time.Duration(q.config.QueueTTLHours) * time.Hour).UnixMilli()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.write(req); err != nil {
+		return QueuedRequest{}, err
+	}
+	log.Printf("[Queue] Queued %s for %s", req.ID, target)
+	return req, nil
+}
+
+// Kick wakes the worker, e.g. after a bridge registered
+func (q *OfflineQueue) Kick() {
+	select {
+	case q.kick <- struct{}{}:
+	default:
+	}
+}
+
+// List returns queued requests, newest first
+func (q *OfflineQueue) List() []QueuedRequest {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	reqs := q.readAll()
+	sort.Slice(reqs, func(i, j int) bool { return reqs[i].CreatedAt > reqs[j].CreatedAt })
+	return reqs
+}
+
+// Cancel removes a queued request
+func (q *OfflineQueue) Cancel(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	err := os.Remove(q.path(id))
+	if os.IsNotExist(err) {
+		return ErrQueuedRequestNotFound
+	}
+	return err
+}
+
+// worker delivers pending requests when kicked and retries every minute
+func (q *OfflineQueue) worker() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.kick:
+		case <-ticker.C:
+		}
+		q.deliverPending()
+	}
+}
+
+func (q *OfflineQueue) deliverPending() {
+	q.mu.Lock()
+	reqs := q.readAll()
+	q.mu.Unlock()
+	sort.Slice(reqs, func(i, j int) bool { return reqs[i].CreatedAt < reqs[j].CreatedAt })
+
+	now := time.Now().UnixMilli()
+	for _, req := range reqs {
+		if req.Status != QueueStatusPending {
+			// Finished requests stay listed for a week
+			if now-req.CreatedAt > 7*24*time.Hour.Milliseconds() {
+				q.Cancel(req.ID)
+			}
+			continue
+		}
+		if req.ExpiresAt > 0 && now > req.ExpiresAt {
+			req.Status = QueueStatusExpired
+			q.save(req)
+			q.notify(req.InstanceID, "요청 만료", fmt.Sprintf("PC가 연결되지 않아 예약된 요청을 처리하지 못했습니다: %s", queuePreview(req)))
+			continue
+		}
+		if len(q.bridges.Candidates(req.Target)) == 0 {
+			continue
+		}
+		q.deliver(req)
+	}
+}
+
+// deliver runs one queued request, stores the answer and announces it
+func (q *OfflineQueue) deliver(req QueuedRequest) {
+	req.Attempts++
+	log.Printf("[Queue] Delivering %s to %s (attempt %d)", req.ID, req.Target, req.Attempts)
+
+	responseCh := make(chan string)
+	errorCh := make(chan error)
+	var result RelayResult
+	go q.relay.RelayChat(context.Background(), req.Target, req.ID, req.Messages, ChatOptions{Agent: req.Agent, Model: req.Model}, q.relay.Timeouts(nil), responseCh, errorCh, nil, nil, &result)
+
+	var sb strings.Builder
+	var relayErr error
+	for responseCh != nil || errorCh != nil {
+		select {
+		case delta, ok := <-responseCh:
+			if !ok {
+				responseCh = nil
+				continue
+			}
+			sb.WriteString(delta)
+		case err, ok := <-errorCh:
+			if !ok {
+				errorCh = nil
+				continue
+			}
+			if err != nil {
+				relayErr = err
+			}
+		}
+	}
+
+	if relayErr != nil {
+		log.Printf("[Queue] %s failed: %v", req.ID, relayErr)
+		req.Error = relayErr.Error()
+		if req.Attempts >= 3 {
+			req.Status = QueueStatusFailed
+			q.notify(req.InstanceID, "요청 실패", fmt.Sprintf("예약된 요청을 처리하지 못했습니다: %s", queuePreview(req)))
+		}
+		q.save(req)
+		return
+	}
+
+	req.Status = QueueStatusDelivered
+	req.Answer = sb.String()
+	req.Error = ""
+	req.DeliveredAt = time.Now().UnixMilli()
+	q.save(req)
+
+	if req.ConversationID != "" {
+		if err := q.storeAnswer(req); err != nil {
+			log.Printf("[Queue] Failed to store answer of %s in %s: %v", req.ID, req.ConversationID, err)
+		}
+	}
+	q.usage.Record(UsageRecord{
+		RequestID:        req.ID,
+		InstanceID:       result.InstanceID,
+		InstanceName:     result.InstanceName,
+		DeviceID:         req.DeviceID,
+		ConversationID:   req.ConversationID,
+		Model:            result.Usage.Model,
+		PromptTokens:     result.Usage.PromptTokens,
+		CompletionTokens: result.Usage.CompletionTokens,
+		TotalTokens:      result.Usage.TotalTokens,
+		Reported:         result.Usage.PromptTokens+result.Usage.CompletionTokens+result.Usage.TotalTokens > 0,
+	})
+
+	body := req.Answer
+	if len([]rune(body)) > 200 {
+		body = string([]rune(body)[:200]) + "…"
+	}
+	q.notify(req.InstanceID, "예약된 요청 완료", body)
+}
+
+// storeAnswer appends the answer to the conversation, preceded by the
+// request's last message unless the app already saved it
+func (q *OfflineQueue) storeAnswer(req QueuedRequest) error {
+	msgs, err := q.conversations.GetMessages(req.ConversationID)
+	if err != nil {
+		return err
+	}
+
+	var toAppend []ConversationMessage
+	if n := len(req.Messages); n > 0 {
+		last := req.Messages[n-1]
+		if len(msgs) == 0 || msgs[len(msgs)-1].Role != last.Role || !msgs[len(msgs)-1].Content.Equal(last.Content) {
+			toAppend = append(toAppend, ConversationMessage{Role: last.Role, Content: last.Content, Attachments: last.Attachments, Timestamp: req.CreatedAt})
+		}
+	}
+	toAppend = append(toAppend, ConversationMessage{Role: "assistant", Content: TextContent(req.Answer)})
+	return q.conversations.AppendMessages(req.ConversationID, toAppend)
+}
+
+// HandleQueue GET /api/queue - list queued requests
+func (q *OfflineQueue) HandleQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(q.List())
+}
+
+// HandleQueueItem DELETE /api/queue/{id} - cancel a queued request
+func (q *OfflineQueue) HandleQueueItem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/queue/")
+	if id == "" || strings.ContainsAny(id, "/\\.") {
+		http.Error(w, "Queued request ID required", http.StatusBadRequest)
+		return
+	}
+	if err := q.Cancel(id); err != nil {
+		if errors.Is(err, ErrQueuedRequestNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (q *OfflineQueue) path(id string) string {
+	return filepath.Join(q.dir, id+".json")
+}
+
+// save writes a request back unless it was cancelled in the meantime
+func (q *OfflineQueue) save(req QueuedRequest) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, err := os.Stat(q.path(req.ID)); err != nil {
+		return
+	}
+	if err := q.write(req); err != nil {
+		log.Printf("[Queue] Failed to save %s: %v", req.ID, err)
+	}
+}
+
+func (q *OfflineQueue) write(req QueuedRequest) error {
+	data, err := json.MarshalIndent(req, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(q.path(req.ID), data, 0644)
+}
+
+func (q *OfflineQueue) readAll() []QueuedRequest {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil
+	}
+	reqs := []QueuedRequest{}
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(q.dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		var req QueuedRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			continue
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs
+}
+
+// queuePreview returns the start of a queued request's last user message
+func queuePreview(req QueuedRequest) string {
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == "user" {
+			text := req.Messages[i].Content.String()
+			if len([]rune(text)) > 50 {
+				text = string([]rune(text)[:50]) + "…"
+			}
+			return text
+		}
+	}
+	return ""
+}
+
+// generateQueueID generates a unique ID for queued requests
+func generateQueueID() string {
+	return fmt.Sprintf("queued_%d", time.Now().UnixNano())
+}
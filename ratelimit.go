@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate limit classes (one set of token buckets per class)
+const (
+	LimitChat    = "chat"
+	LimitYouTube = "youtube"
+	LimitPush    = "push"
+	LimitRPC     = "rpc"
+)
+
+// tokenBucket refills at rate tokens per second up to burst
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter keeps token buckets per key (device or IP) for one class
+type RateLimiter struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// NewRateLimiter creates a limiter allowing perMinute requests per key, with
+// bursts of up to a minute's worth
+func NewRateLimiter(perMinute int) *RateLimiter {
+	return &RateLimiter{
+		rate:      float64(perMinute) / 60,
+		burst:     float64(perMinute),
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token from each key's bucket, or from none of them: if any
+// bucket is empty it returns false and how long until all have a token.
+func (rl *RateLimiter) Allow(keys ...string) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	rl.sweep(now)
+
+	buckets := make([]*tokenBucket, len(keys))
+	var wait time.Duration
+	for i, key := range keys {
+		b, ok := rl.buckets[key]
+		if !ok {
+			b = &tokenBucket{tokens: rl.burst, last: now}
+			rl.buckets[key] = b
+		}
+		b.tokens = math.Min(rl.burst, b.tokens+now.Sub(b.last).Seconds()*rl.rate)
+		b.last = now
+		if b.tokens < 1 {
+			wait = max(wait, time.Duration((1-b.tokens)/rl.rate*float64(time.Second)))
+		}
+		buckets[i] = b
+	}
+
+	if wait > 0 {
+		return false, wait
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return true, 0
+}
+
+// sweep drops buckets that have been idle long enough to be full again
+func (rl *RateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < 10*time.Minute {
+		return
+	}
+	rl.lastSweep = now
+	full := time.Duration(rl.burst / rl.rate * float64(time.Second))
+	for key, b := range rl.buckets {
+		if now.Sub(b.last) > full {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// newRateLimiters creates limiters for the classes that have a limit configured
+func newRateLimiters(config *Config) map[string]*RateLimiter {
+	limiters := make(map[string]*RateLimiter)
+	if config.ChatRatePerMinute > 0 {
+		limiters[LimitChat] = NewRateLimiter(config.ChatRatePerMinute)
+	}
+	if config.YouTubeRatePerMinute > 0 {
+		limiters[LimitYouTube] = NewRateLimiter(config.YouTubeRatePerMinute)
+	}
+	if config.PushRatePerMinute > 0 {
+		limiters[LimitPush] = NewRateLimiter(config.PushRatePerMinute)
+	}
+	if config.RPCRatePerMinute > 0 {
+		limiters[LimitRPC] = NewRateLimiter(config.RPCRatePerMinute)
+	}
+	return limiters
+}
+
+// limit wraps a handler with the rate limits of class: a token bucket per
+// device (X-Device-ID) and per client IP, plus the daily token quota for chat
+// requests, counted per client IP and per device
+func (api *APIServer) limit(class string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next(w, r)
+			return
+		}
+
+		device, ip := deviceID(r), api.clientIP(r)
+		if class == LimitChat && api.config.DeviceDailyTokenQuota > 0 {
+			for _, key := range usageQuotaKeys(device, ip) {
+				if used := api.usage.TokensToday(key); used >= api.config.DeviceDailyTokenQuota {
+					now := time.Now()
+					midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+					log.Printf("[RateLimit] Daily token quota exceeded for %s (%d tokens)", key, used)
+					tooManyRequests(w, midnight.Sub(now), fmt.Sprintf("Daily token quota exceeded (%d/%d)", used, api.config.DeviceDailyTokenQuota))
+					return
+				}
+			}
+		}
+
+		if rl := api.limiters[class]; rl != nil {
+			keys := []string{"ip:" + ip}
+			if device != "" {
+				keys = append(keys, "device:"+device)
+			}
+			if ok, wait := rl.Allow(keys...); !ok {
+				log.Printf("[RateLimit] %s limit hit for %s", class, strings.Join(keys, ", "))
+				tooManyRequests(w, wait, fmt.Sprintf("Too many %s requests", class))
+				return
+			}
+		}
+
+		next(w, r)
+	}
+}
+
+// clientIP returns the request's client address, honouring proxy headers
+// only when TRUST_PROXY_HEADERS is set
+func (api *APIServer) clientIP(r *http.Request) string {
+	if api.config.TrustProxyHeaders {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			return strings.TrimSpace(strings.Split(fwd, ",")[0])
+		}
+		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			return strings.TrimSpace(realIP)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// tooManyRequests writes a 429 with Retry-After rounded up to whole seconds
+func tooManyRequests(w http.ResponseWriter, wait time.Duration, msg string) {
+	secs := int(math.Ceil(wait.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	http.Error(w, msg, http.StatusTooManyRequests)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"time"
@@ -20,112 +21,304 @@ func NewRelayManager(bridgeManager *BridgeManager, config *Config) *RelayManager
 	}
 }
 
-// RelayChat relays a chat request to the specified bridge and streams responses
-func (rm *RelayManager) RelayChat(bridgeID, requestID string, messages []ChatMessage, user string, responseCh chan<- string, errorCh chan<- error, fileCh chan<- FileResponseMessage) {
+// RelayTimeouts bounds how long a relayed chat request may take
+type RelayTimeouts struct {
+	FirstByte time.Duration // until the bridge sends anything
+	Idle      time.Duration // between messages from the bridge
+	Total     time.Duration // for the whole request, including failover
+}
+
+// ChatTimeouts lets a chat request adjust its timeouts, in seconds
+type ChatTimeouts struct {
+	FirstByteSeconds int `json:"firstByteSeconds,omitempty"`
+	IdleSeconds      int `json:"idleSeconds,omitempty"`
+	TotalSeconds     int `json:"totalSeconds,omitempty"`
+}
+
+// Timeouts returns the configured relay timeouts with a request's overrides
+// applied, each capped at RELAY_TIMEOUT_LIMIT_SECONDS
+func (rm *RelayManager) Timeouts(override *ChatTimeouts) RelayTimeouts {
+	pick := func(configured, requested int) time.Duration {
+		secs := configured
+		if requested > 0 {
+			secs = requested
+		}
+		if limit := rm.config.RelayTimeoutLimitSeconds; limit > 0 && secs > limit {
+			secs = limit
+		}
+		return time.Duration(secs) * time.Second
+	}
+
+	var o ChatTimeouts
+	if override != nil {
+		o = *override
+	}
+	return RelayTimeouts{
+		FirstByte: pick(rm.config.RelayFirstByteTimeoutSeconds, o.FirstByteSeconds),
+		Idle:      pick(rm.config.RelayIdleTimeoutSeconds, o.IdleSeconds),
+		Total:     pick(rm.config.RelayTotalTimeoutSeconds, o.TotalSeconds),
+	}
+}
+
+// RelayResult describes how a relayed chat request was served
+type RelayResult struct {
+	InstanceID   string // bridge that answered
+	InstanceName string
+	Usage        ChatUsage // token usage, if the bridge reported it
+}
+
+// RelayChat relays a chat request to a bridge and streams responses. target
+// is a bridge ID, "default" or "group:<name>"; for the latter two the least
+// busy healthy member is used, failing over to the next one if it errors
+// before sending any text. A nil fileCh or eventCh means the caller is not
+// interested in files or chat events. Sends block until the caller takes
+// them, which holds back the bridge through flow control; cancelling ctx
+// (e.g. the app disconnected) cancels the request on the bridge. If result is
+// non-nil it is filled in before responseCh is closed.
+func (rm *RelayManager) RelayChat(ctx context.Context, target, requestID string, messages []ChatMessage, opts ChatOptions, timeouts RelayTimeouts, responseCh chan<- string, errorCh chan<- error, fileCh chan<- FileResponseMessage, eventCh chan<- ChatEventMessage, result *RelayResult) {
 	defer close(responseCh)
 	defer close(errorCh)
-	defer close(fileCh)
+	defer func() {
+		if fileCh != nil {
+			close(fileCh)
+		}
+		if eventCh != nil {
+			close(eventCh)
+		}
+	}()
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("RelayChat panic recovered: %v", r)
 		}
 	}()
 
-	// Get the bridge connection
-	bridge := rm.bridgeManager.GetBridge(bridgeID)
-	if bridge == nil {
-		errorCh <- fmt.Errorf("bridge not found: %s", bridgeID)
+	fail := func(err error) {
+		select {
+		case errorCh <- err:
+		case <-ctx.Done():
+		}
+	}
+
+	candidates := rm.bridgeManager.Candidates(target)
+	if len(candidates) == 0 {
+		fail(fmt.Errorf("bridge not found: %s", target))
+		return
+	}
+	if candidates = offering(candidates, opts); len(candidates) == 0 {
+		fail(fmt.Errorf("no bridge for %s offers agent %q / model %q", target, opts.Agent, opts.Model))
 		return
 	}
 
-	// Register per-request channels (fixes shared channel fan-out bug)
-	reqCh := bridge.RegisterRequest(requestID)
+	// The total timeout covers failover; fail still reports to the caller after it
+	relayCtx, cancel := context.WithTimeout(ctx, timeouts.Total)
+	defer cancel()
+	for i, bridge := range candidates {
+		started, err := rm.relayTo(relayCtx, bridge, requestID, messages, opts, timeouts, responseCh, fileCh, eventCh, result)
+		if err == nil {
+			return
+		}
+		if started || i == len(candidates)-1 || relayCtx.Err() != nil {
+			fail(err)
+			return
+		}
+		log.Printf("Bridge %s (%s) failed before responding, failing over: %v", bridge.Name, bridge.ID, err)
+	}
+}
+
+// offering keeps the bridges that can serve opts' agent and model
+func offering(bridges []*BridgeConnection, opts ChatOptions) []*BridgeConnection {
+	var out []*BridgeConnection
+	for _, bridge := range bridges {
+		if bridge.Offers(opts.Agent, opts.Model) {
+			out = append(out, bridge)
+		}
+	}
+	return out
+}
+
+// relayTo runs a chat request on one bridge. started reports whether any text
+// reached responseCh, after which the request can no longer be retried.
+func (rm *RelayManager) relayTo(ctx context.Context, bridge *BridgeConnection, requestID string, messages []ChatMessage, opts ChatOptions, timeouts RelayTimeouts, responseCh chan<- string, fileCh chan<- FileResponseMessage, eventCh chan<- ChatEventMessage, result *RelayResult) (started bool, err error) {
+	// Register a per-request stream (fixes shared channel fan-out bug)
+	stream := bridge.RegisterRequest(requestID, rm.config.RequestBufferKB*1024)
 	defer bridge.UnregisterRequest(requestID)
+	defer func() { bridge.health.finished(ctx, err) }()
 
-	// Send chat request to bridge
-	err := rm.bridgeManager.SendChatRequest(bridgeID, requestID, messages, user)
-	if err != nil {
-		errorCh <- fmt.Errorf("failed to send chat request: %v", err)
-		return
+	// Send chat request to bridge; only bridges declaring flow control get a window
+	window := 0
+	if containsString(bridge.Capabilities, CapabilityFlowControl) {
+		window = rm.config.ChatFlowWindow
+	}
+	if err := rm.bridgeManager.SendChatRequest(bridge.ID, requestID, messages, opts, window); err != nil {
+		return false, fmt.Errorf("failed to send chat request: %v", err)
+	}
+
+	log.Printf("Chat request sent to bridge %s (request: %s)", bridge.ID, requestID)
+
+	// stop cancels the request on the bridge when we give up on it
+	stop := func(reason error) (bool, error) {
+		if ctx.Err() == context.DeadlineExceeded {
+			reason = fmt.Errorf("timeout: request exceeded %s", timeouts.Total)
+		}
+		rm.bridgeManager.CancelChatRequest(bridge, requestID, reason.Error())
+		return started, reason
 	}
 
-	log.Printf("Chat request sent to bridge %s (request: %s)", bridgeID, requestID)
+	// The idle timer starts with the first-byte timeout and is reset to the
+	// idle timeout whenever the bridge sends anything
+	idle := time.NewTimer(timeouts.FirstByte)
+	defer idle.Stop()
+	idleErr := fmt.Errorf("timeout waiting for first response")
+	active := func() {
+		idle.Reset(timeouts.Idle)
+		idleErr = fmt.Errorf("timeout: no response for %s", timeouts.Idle)
+	}
 
-	// Wait for responses with timeout
-	timeout := time.NewTimer(2 * time.Minute)
-	defer timeout.Stop()
+	// Window-counted frames taken by the app are handed back to the bridge as
+	// credit in batches of half the window
+	consumed := 0
+	taken := func() {
+		consumed++
+		if window > 0 && consumed >= (window+1)/2 {
+			if err := rm.bridgeManager.SendCredit(bridge, requestID, consumed); err != nil {
+				log.Printf("Failed to send credit for %s to bridge %s: %v", requestID, bridge.ID, err)
+			}
+			consumed = 0
+		}
+	}
 
 	for {
-		select {
-		case response, ok := <-reqCh.ResponseCh:
-			if !ok {
-				errorCh <- fmt.Errorf("bridge disconnected")
-				return
+		msg, closed := stream.Next()
+		if msg == nil {
+			if closed {
+				return started, fmt.Errorf("bridge disconnected")
 			}
-			if response.Delta != "" {
+			select {
+			case <-stream.Ready():
+				continue
+			case <-idle.C:
+				return stop(idleErr)
+			case <-ctx.Done():
+				return stop(ctx.Err())
+			}
+		}
+		active()
+
+		switch m := msg.(type) {
+		case ChatResponseMessage:
+			if m.Delta != "" {
 				select {
-				case responseCh <- response.Delta:
-				case <-timeout.C:
-					errorCh <- fmt.Errorf("timeout")
-					return
+				case responseCh <- m.Delta:
+					started = true
+				case <-ctx.Done():
+					return stop(ctx.Err())
 				}
 			}
-			if response.Done {
+			if m.Done {
 				log.Printf("Chat request completed: %s", requestID)
-				// Drain file events briefly (non-blocking)
-				go rm.drainFileEvents(reqCh, fileCh, 10*time.Second)
-				return
+				if result != nil {
+					result.InstanceID = bridge.ID
+					result.InstanceName = bridge.Name
+					if m.Usage != nil {
+						result.Usage = *m.Usage
+					}
+				}
+				rm.drainFiles(ctx, stream, fileCh, time.Duration(rm.config.FileDrainSeconds)*time.Second)
+				return started, nil
 			}
 
-		case chatError, ok := <-reqCh.ErrorCh:
-			if !ok {
-				errorCh <- fmt.Errorf("bridge disconnected")
-				return
-			}
-			errorCh <- fmt.Errorf("chat error: %s", chatError.Error)
-			return
+		case ChatErrorMessage:
+			return started, fmt.Errorf("chat error: %s", m.Error)
 
-		case fileMsg, ok := <-reqCh.FileCh:
-			if !ok {
-				continue
+		case FileResponseMessage:
+			if fileCh != nil {
+				select {
+				case fileCh <- m:
+				case <-ctx.Done():
+					return stop(ctx.Err())
+				}
 			}
-			select {
-			case fileCh <- fileMsg:
-			default:
+			if m.stored {
+				continue
 			}
 
-		case <-timeout.C:
-			errorCh <- fmt.Errorf("timeout waiting for response")
-			return
+		case ChatEventMessage:
+			if eventCh != nil {
+				select {
+				case eventCh <- m:
+				case <-ctx.Done():
+					return stop(ctx.Err())
+				}
+			}
 		}
+		taken()
 	}
 }
 
-// drainFileEvents waits briefly for file events after chat completion
-func (rm *RelayManager) drainFileEvents(reqCh *RequestChannels, fileCh chan<- FileResponseMessage, duration time.Duration) {
+// drainFiles passes on file responses already buffered after completion and
+// waits up to duration for late ones. It runs before RelayChat closes fileCh.
+func (rm *RelayManager) drainFiles(ctx context.Context, stream *RequestStream, fileCh chan<- FileResponseMessage, duration time.Duration) {
 	timer := time.NewTimer(duration)
 	defer timer.Stop()
 	for {
-		select {
-		case fileMsg, ok := <-reqCh.FileCh:
-			if !ok {
+		msg, closed := stream.Next()
+		if msg == nil {
+			if closed || duration <= 0 {
 				return
 			}
 			select {
-			case fileCh <- fileMsg:
-			default:
+			case <-stream.Ready():
+				continue
+			case <-timer.C:
+				return
+			case <-ctx.Done():
+				return
 			}
-		case <-timer.C:
+		}
+		fileMsg, ok := msg.(FileResponseMessage)
+		if !ok || fileCh == nil {
+			continue
+		}
+		select {
+		case fileCh <- fileMsg:
+		case <-ctx.Done():
 			return
 		}
 	}
 }
 
+// isLocalTarget reports whether a chat target is served by the local OpenClaw gateway
+func isLocalTarget(target string, config *Config) bool {
+	return target == "local" || (target == "default" && config.DefaultInstance == "local")
+}
+
 // ChatRequest represents an incoming chat request
 type ChatRequest struct {
 	InstanceID     string        `json:"instanceId"`
 	Messages       []ChatMessage `json:"messages"`
 	ConversationID string        `json:"conversationId,omitempty"`
+	// Events opts in to chat_event SSE events (tool_start, tool_end, status, thinking)
+	Events bool `json:"events,omitempty"`
+	// Timeouts overrides the server's relay timeouts, within its limit
+	Timeouts *ChatTimeouts `json:"timeouts,omitempty"`
+	// Queue accepts the request while the target bridge is offline and runs
+	// it when the bridge reconnects; the answer arrives as a notification
+	Queue bool `json:"queue,omitempty"`
+	// Agent and Model pick one the bridge lists in /api/instances
+	Agent string `json:"agent,omitempty"`
+	Model string `json:"model,omitempty"`
+}
+
+// ChatOptions are passed through to the bridge with a chat request
+type ChatOptions struct {
+	User  string // OpenAI "user" field
+	Agent string
+	Model string
+}
+
+// Options returns the request's pass-through options
+func (req *ChatRequest) Options() ChatOptions {
+	return ChatOptions{Agent: req.Agent, Model: req.Model}
 }
 
 // ValidateChatRequest validates a chat request
@@ -140,9 +333,17 @@ func (rm *RelayManager) ValidateChatRequest(req *ChatRequest) error {
 		if msg.Role == "" {
 			return fmt.Errorf("message[%d]: role is required", i)
 		}
-		if msg.Content == "" {
+		if msg.Content.IsEmpty() && len(msg.Attachments) == 0 {
 			return fmt.Errorf("message[%d]: content is required", i)
 		}
+		if err := msg.Content.Validate(); err != nil {
+			return fmt.Errorf("message[%d]: %v", i, err)
+		}
+		for j, att := range msg.Attachments {
+			if att.FileID == "" {
+				return fmt.Errorf("message[%d].attachments[%d]: fileId is required", i, j)
+			}
+		}
 		if msg.Role != "user" && msg.Role != "assistant" && msg.Role != "system" {
 			return fmt.Errorf("message[%d]: invalid role '%s'", i, msg.Role)
 		}
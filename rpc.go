@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// rpcMethodPattern limits method names to what a bridge allowlist can name
+var rpcMethodPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// maxRPCBody bounds the body of POST /api/instances/{id}/rpc
+const maxRPCBody = 1 << 20
+
+var rpcSeq uint64
+
+// RPCError is an error answered by the bridge
+type RPCError struct {
+	Message string
+	Code    string
+}
+
+func (e *RPCError) Error() string {
+	if e.Code != "" {
+		return e.Code + ": " + e.Message
+	}
+	return e.Message
+}
+
+// CallRPC runs method on bridge and waits for its answer until ctx is done
+func (bm *BridgeManager) CallRPC(ctx context.Context, bridge *BridgeConnection, method string, params json.RawMessage) (json.RawMessage, error) {
+	id := fmt.Sprintf("rpc_%d_%d", time.Now().UnixNano(), atomic.AddUint64(&rpcSeq, 1))
+	ch := make(chan RPCResponseMessage, 1)
+	bridge.rpcsMu.Lock()
+	bridge.rpcs[id] = ch
+	bridge.rpcsMu.Unlock()
+	defer func() {
+		bridge.rpcsMu.Lock()
+		delete(bridge.rpcs, id)
+		bridge.rpcsMu.Unlock()
+	}()
+
+	req := RPCRequestMessage{Type: MsgTypeRPCRequest, ID: id, Method: method, Params: params}
+	if err := bridge.Send(req); err != nil {
+		return nil, fmt.Errorf("failed to send rpc request: %v", err)
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Error != "" || resp.Code != "" {
+			return nil, &RPCError{Message: resp.Error, Code: resp.Code}
+		}
+		return resp.Result, nil
+	case <-bridge.closed:
+		return nil, ErrBridgeClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// resolveRPC hands an rpc_response to the call waiting for it
+func (bc *BridgeConnection) resolveRPC(resp RPCResponseMessage) {
+	bc.rpcsMu.Lock()
+	ch, ok := bc.rpcs[resp.ID]
+	bc.rpcsMu.Unlock()
+	if !ok {
+		log.Printf("RPC response for unknown call %s from bridge %s", resp.ID, bc.ID)
+		return
+	}
+	select {
+	case ch <- resp:
+	default:
+	}
+}
+
+// handleInstanceRPC handles POST /api/instances/{id}/rpc with
+// {"method": "...", "params": {...}} and returns {"result": ...}. It needs
+// RPC_TOKEN (or ADMIN_TOKEN).
+func (api *APIServer) handleInstanceRPC(w http.ResponseWriter, r *http.Request, instanceID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !api.rpcAuthorized(w, r) {
+		return
+	}
+
+	var req struct {
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params,omitempty"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRPCBody)).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !rpcMethodPattern.MatchString(req.Method) {
+		http.Error(w, "method must be 1-64 letters, digits, '_', '.' or '-'", http.StatusBadRequest)
+		return
+	}
+
+	candidates := api.bridgeManager.Candidates(instanceID)
+	if len(candidates) == 0 {
+		http.Error(w, "Instance not found", http.StatusNotFound)
+		return
+	}
+	bridge := candidates[0]
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(api.config.RPCTimeoutSeconds)*time.Second)
+	defer cancel()
+
+	result, err := api.bridgeManager.CallRPC(ctx, bridge, req.Method, req.Params)
+	log.Printf("[RPC] %s on %s (%s): err=%v", req.Method, bridge.Name, bridge.ID, err)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		status := http.StatusBadGateway
+		resp := map[string]string{"error": err.Error()}
+		if rpcErr, ok := err.(*RPCError); ok {
+			resp = map[string]string{"error": rpcErr.Message, "code": rpcErr.Code}
+			switch rpcErr.Code {
+			case "not_allowed":
+				status = http.StatusForbidden
+			case "not_found":
+				status = http.StatusNotFound
+			}
+		} else if ctx.Err() == context.DeadlineExceeded {
+			status = http.StatusGatewayTimeout
+			resp["error"] = fmt.Sprintf("timeout: bridge did not answer within %ds", api.config.RPCTimeoutSeconds)
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if len(result) == 0 {
+		result = json.RawMessage("null")
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"instanceId": bridge.ID,
+		"result":     result,
+	})
+}
+
+// rpcAuthorized checks "Authorization: Bearer <RPC_TOKEN>", also taking
+// ADMIN_TOKEN. It answers 404 while neither is set and 401 for a wrong token.
+func (api *APIServer) rpcAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	if api.config.RPCToken == "" && api.config.AdminToken == "" {
+		http.NotFound(w, r)
+		return false
+	}
+	token, err := ExtractBearerToken(r)
+	if err == nil {
+		for _, want := range []string{api.config.RPCToken, api.config.AdminToken} {
+			if want != "" && subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1 {
+				return true
+			}
+		}
+	}
+	log.Printf("[RPC] Unauthorized %s %s from %s", r.Method, r.URL.Path, api.clientIP(r))
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	return false
+}
+
+// handleInstanceByID routes /api/instances/{id}/...
+func (api *APIServer) handleInstanceByID(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/instances/"), "/")
+	if len(parts) == 2 && parts[0] != "" && parts[1] == "rpc" {
+		api.handleInstanceRPC(w, r, parts[0])
+		return
+	}
+	http.NotFound(w, r)
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage categories (top-level entries under DataDir)
+const (
+	StorageConversations = "conversations"
+	StorageAPK           = "apk"
+	StorageFCM           = "fcm"
+	StorageOther         = "other"
+)
+
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// StorageUsage is the disk usage of one category
+type StorageUsage struct {
+	Bytes      int64 `json:"bytes"`
+	Files      int   `json:"files"`
+	QuotaBytes int64 `json:"quotaBytes,omitempty"`
+}
+
+// JanitorResult records what one janitor run cleaned up
+type JanitorResult struct {
+	At                   time.Time `json:"at"`
+	TrashedConversations int       `json:"trashedConversations"`
+	PurgedConversations  int       `json:"purgedConversations"`
+	PrunedAPKBuilds      int       `json:"prunedApkBuilds"`
+	PrunedFCMTokens      int       `json:"prunedFcmTokens"`
+	ExpiredFiles         int       `json:"expiredFiles"`
+	Errors               []string  `json:"errors,omitempty"`
+}
+
+// StorageManager enforces retention policies and quotas for DataDir
+type StorageManager struct {
+	config        *Config
+	conversations *ConversationStore
+	apk           *APKHandler
+	fcm           *FcmManager
+	files         *FileStore
+
+	mu         sync.Mutex
+	usage      map[string]StorageUsage
+	scannedAt  time.Time
+	lastResult *JanitorResult
+}
+
+// NewStorageManager creates a storage manager
+func NewStorageManager(config *Config, conversations *ConversationStore, apk *APKHandler, fcm *FcmManager, files *FileStore) *StorageManager {
+	return &StorageManager{
+		config:        config,
+		conversations: conversations,
+		apk:           apk,
+		fcm:           fcm,
+		files:         files,
+	}
+}
+
+// StartJanitor runs the janitor immediately and then every interval
+func (sm *StorageManager) StartJanitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		sm.RunJanitor()
+		<-ticker.C
+	}
+}
+
+// RunJanitor applies all retention policies once and rescans disk usage
+func (sm *StorageManager) RunJanitor() JanitorResult {
+	res := JanitorResult{At: time.Now()}
+	day := 24 * time.Hour
+
+	if days := sm.config.ConversationRetentionDays; days > 0 {
+		n, err := sm.conversations.TrashInactive(time.Duration(days) * day)
+		res.TrashedConversations = n
+		if err != nil {
+			res.Errors = append(res.Errors, fmt.Sprintf("trash inactive conversations: %v", err))
+		}
+	}
+	if days := sm.config.TrashRetentionDays; days > 0 {
+		n, err := sm.conversations.PurgeTrash(time.Duration(days) * day)
+		res.PurgedConversations = n
+		if err != nil {
+			res.Errors = append(res.Errors, fmt.Sprintf("purge trash: %v", err))
+		}
+	}
+	if keep := sm.config.APKKeepBuilds; keep > 0 {
+		n, err := sm.apk.PruneBuilds(keep)
+		res.PrunedAPKBuilds = n
+		if err != nil {
+			res.Errors = append(res.Errors, fmt.Sprintf("prune APK builds: %v", err))
+		}
+	}
+	if days := sm.config.FCMTokenRetentionDays; days > 0 && sm.fcm != nil {
+		res.PrunedFCMTokens = sm.fcm.PruneTokens(time.Duration(days) * day)
+	}
+	if sm.files != nil {
+		n, err := sm.files.PurgeExpired()
+		res.ExpiredFiles = n
+		if err != nil {
+			res.Errors = append(res.Errors, fmt.Sprintf("purge expired files: %v", err))
+		}
+	}
+
+	for _, e := range res.Errors {
+		log.Printf("[Storage] Janitor error: %s", e)
+	}
+	if res.TrashedConversations+res.PurgedConversations+res.PrunedAPKBuilds+res.PrunedFCMTokens+res.ExpiredFiles > 0 {
+		log.Printf("[Storage] Janitor: trashed=%d purged=%d apkBuilds=%d fcmTokens=%d files=%d",
+			res.TrashedConversations, res.PurgedConversations, res.PrunedAPKBuilds, res.PrunedFCMTokens, res.ExpiredFiles)
+	}
+
+	sm.mu.Lock()
+	sm.lastResult = &res
+	sm.mu.Unlock()
+	sm.rescan()
+	return res
+}
+
+// CheckQuota returns an error wrapping ErrQuotaExceeded if writing incoming
+// more bytes into category would exceed its quota or the DataDir quota.
+func (sm *StorageManager) CheckQuota(category string, incoming int64) error {
+	if incoming < 0 {
+		incoming = 0
+	}
+	usage := sm.currentUsage()
+
+	if quota := sm.quotaBytes(category); quota > 0 {
+		if used := usage[category].Bytes; used+incoming > quota {
+			return fmt.Errorf("%w: %s uses %s of %s", ErrQuotaExceeded, category, formatBytes(used), formatBytes(quota))
+		}
+	}
+	if quota := mbToBytes(sm.config.DataQuotaMB); quota > 0 {
+		var total int64
+		for _, u := range usage {
+			total += u.Bytes
+		}
+		if total+incoming > quota {
+			return fmt.Errorf("%w: data directory uses %s of %s", ErrQuotaExceeded, formatBytes(total), formatBytes(quota))
+		}
+	}
+	return nil
+}
+
+// LimitReader wraps r so that reading more bytes than category may still take
+// fails with an error wrapping ErrQuotaExceeded. Unlike CheckQuota it also
+// holds for chunked bodies, whose ContentLength is -1.
+func (sm *StorageManager) LimitReader(category string, r io.Reader) io.Reader {
+	left := sm.quotaLeft(category)
+	if left < 0 {
+		return r
+	}
+	return &quotaReader{r: r, remaining: left, err: sm.CheckQuota(category, left+1)}
+}
+
+// quotaLeft returns how many more bytes category may take, -1 if unlimited
+func (sm *StorageManager) quotaLeft(category string) int64 {
+	usage := sm.currentUsage()
+	left := int64(-1)
+	if quota := sm.quotaBytes(category); quota > 0 {
+		left = max(quota-usage[category].Bytes, 0)
+	}
+	if quota := mbToBytes(sm.config.DataQuotaMB); quota > 0 {
+		var total int64
+		for _, u := range usage {
+			total += u.Bytes
+		}
+		if l := max(quota-total, 0); left < 0 || l < left {
+			left = l
+		}
+	}
+	return left
+}
+
+// quotaReader fails with err once more than remaining bytes have been read
+type quotaReader struct {
+	r         io.Reader
+	remaining int64
+	err       error
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	if q.remaining < 0 {
+		return 0, q.err
+	}
+	if int64(len(p)) > q.remaining+1 {
+		p = p[:q.remaining+1]
+	}
+	n, err := q.r.Read(p)
+	if int64(n) <= q.remaining {
+		q.remaining -= int64(n)
+		return n, err
+	}
+	n = int(q.remaining)
+	q.remaining = -1
+	return n, q.err
+}
+
+// HandleReport GET /api/storage - disk usage, quotas and retention settings
+func (sm *StorageManager) HandleReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.URL.Query().Get("rescan") == "true" {
+		sm.rescan()
+	}
+	usage := sm.currentUsage()
+
+	var total int64
+	categories := make(map[string]StorageUsage, len(usage))
+	for name, u := range usage {
+		u.QuotaBytes = sm.quotaBytes(name)
+		categories[name] = u
+		total += u.Bytes
+	}
+
+	sm.mu.Lock()
+	scannedAt := sm.scannedAt
+	lastResult := sm.lastResult
+	sm.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"dataDir":    sm.config.DataDir,
+		"totalBytes": total,
+		"quotaBytes": mbToBytes(sm.config.DataQuotaMB),
+		"categories": categories,
+		"retention": map[string]int{
+			"conversationDays": sm.config.ConversationRetentionDays,
+			"trashDays":        sm.config.TrashRetentionDays,
+			"apkKeepBuilds":    sm.config.APKKeepBuilds,
+			"fcmTokenDays":     sm.config.FCMTokenRetentionDays,
+			"fileHours":        sm.config.FileTTLHours,
+		},
+		"scannedAt":   scannedAt,
+		"lastJanitor": lastResult,
+	})
+}
+
+// currentUsage returns cached usage, rescanning if it is older than a minute
+func (sm *StorageManager) currentUsage() map[string]StorageUsage {
+	sm.mu.Lock()
+	fresh := sm.usage != nil && time.Since(sm.scannedAt) < time.Minute
+	usage := sm.usage
+	sm.mu.Unlock()
+
+	if fresh {
+		return usage
+	}
+	return sm.rescan()
+}
+
+// rescan walks DataDir and caches per-category usage
+func (sm *StorageManager) rescan() map[string]StorageUsage {
+	usage := make(map[string]StorageUsage)
+	root := sm.config.DataDir
+
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		cat := storageCategory(rel)
+		u := usage[cat]
+		u.Bytes += info.Size()
+		u.Files++
+		usage[cat] = u
+		return nil
+	})
+
+	sm.mu.Lock()
+	sm.usage = usage
+	sm.scannedAt = time.Now()
+	sm.mu.Unlock()
+	return usage
+}
+
+// quotaBytes returns the configured quota for a category (0 = unlimited)
+func (sm *StorageManager) quotaBytes(category string) int64 {
+	switch category {
+	case StorageConversations:
+		return mbToBytes(sm.config.ConversationQuotaMB)
+	case StorageAPK:
+		return mbToBytes(sm.config.APKQuotaMB)
+	case StorageFiles:
+		return mbToBytes(sm.config.FilesQuotaMB)
+	}
+	return 0
+}
+
+// storageCategory maps a path relative to DataDir to its category
+func storageCategory(rel string) string {
+	first := strings.Split(filepath.ToSlash(rel), "/")[0]
+	if first == rel {
+		// Top-level file
+		if strings.HasPrefix(first, "fcm_") {
+			return StorageFCM
+		}
+		return StorageOther
+	}
+	return first
+}
+
+func mbToBytes(mb int) int64 {
+	return int64(mb) * 1024 * 1024
+}
+
+// formatBytes renders a byte count for error messages
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// RequestStream buffers everything a bridge sends for one chat request, in
+// arrival order. The bridge reader never blocks on it, so a slow app only
+// holds up its own request; flow-control credits (chat_credit) keep the
+// bridge from sending far more than the app has taken. Bridges that ignore
+// the window are cut off with an error once limit bytes are buffered,
+// instead of silently losing deltas.
+type RequestStream struct {
+	limit   int // bytes, 0 = unlimited
+	started time.Time
+
+	mu       sync.Mutex
+	frames   []streamFrame
+	bytes    int
+	closed   bool // bridge disconnected
+	overflow bool
+	ready    chan struct{}
+}
+
+// streamFrame is one buffered message: ChatResponseMessage, ChatErrorMessage,
+// FileResponseMessage or ChatEventMessage
+type streamFrame struct {
+	msg  interface{}
+	size int
+}
+
+// NewRequestStream creates a stream buffering up to limit bytes
+func NewRequestStream(limit int) *RequestStream {
+	return &RequestStream{
+		limit:   limit,
+		started: time.Now(),
+		ready:   make(chan struct{}, 1),
+	}
+}
+
+// Push appends a message of size bytes (its encoded length). It returns false
+// when the message overflows the buffer; the stream then ends with an error
+// after the frames already buffered, and later messages are ignored.
+func (s *RequestStream) Push(msg interface{}, size int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed || s.overflow {
+		return true
+	}
+	if s.limit > 0 && s.bytes+size > s.limit {
+		s.overflow = true
+		s.signal()
+		return false
+	}
+	s.frames = append(s.frames, streamFrame{msg: msg, size: size})
+	s.bytes += size
+	s.signal()
+	return true
+}
+
+// Close marks the bridge as gone; buffered frames can still be read
+func (s *RequestStream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.signal()
+}
+
+// Next returns the oldest buffered message, or nil if there is none yet.
+// closed reports that nothing more will arrive.
+func (s *RequestStream) Next() (msg interface{}, closed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.frames) == 0 {
+		if s.overflow {
+			s.overflow = false
+			s.closed = true
+			return ChatErrorMessage{Type: MsgTypeChatError, Error: "response buffer overflow: bridge ignored flow control"}, false
+		}
+		return nil, s.closed
+	}
+	f := s.frames[0]
+	s.frames[0] = streamFrame{}
+	s.frames = s.frames[1:]
+	s.bytes -= f.size
+	return f.msg, false
+}
+
+// Buffered returns the bytes waiting for the app
+func (s *RequestStream) Buffered() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bytes
+}
+
+// Ready is signalled when a message is pushed or the stream closes
+func (s *RequestStream) Ready() <-chan struct{} {
+	return s.ready
+}
+
+func (s *RequestStream) signal() {
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	titlePrompt = "Write a short title (at most 30 characters) for the conversation above, " +
+		"in the same language the user writes in. Reply with the title only, no quotes."
+	summaryPrompt = "Summarize the conversation above in a few sentences, in the same language " +
+		"the user writes in. Keep names, decisions and open questions. Reply with the summary only."
+)
+
+// Summarizer generates conversation titles and rolling summaries in the
+// background by sending short chat requests to the conversation's instance.
+type Summarizer struct {
+	store  *ConversationStore
+	relay  *RelayManager
+	usage  *UsageLedger
+	config *Config
+
+	queue   chan string
+	mu      sync.Mutex
+	pending map[string]bool
+}
+
+// NewSummarizer creates a summarizer and starts its worker
+func NewSummarizer(store *ConversationStore, relay *RelayManager, usage *UsageLedger, config *Config) *Summarizer {
+	s := &Summarizer{
+		store:   store,
+		relay:   relay,
+		usage:   usage,
+		config:  config,
+		queue:   make(chan string, 64),
+		pending: make(map[string]bool),
+	}
+	go s.worker()
+	return s
+}
+
+// Notify schedules a conversation for titling/summarization. Duplicate
+// notifications for a conversation already queued are collapsed.
+func (s *Summarizer) Notify(conversationID string) {
+	s.mu.Lock()
+	if s.pending[conversationID] {
+		s.mu.Unlock()
+		return
+	}
+	s.pending[conversationID] = true
+	s.mu.Unlock()
+
+	select {
+	case s.queue <- conversationID:
+	default:
+		s.mu.Lock()
+		delete(s.pending, conversationID)
+		s.mu.Unlock()
+		log.Printf("[Summarize] Queue full, skipping %s", conversationID)
+	}
+}
+
+func (s *Summarizer) worker() {
+	for id := range s.queue {
+		s.mu.Lock()
+		delete(s.pending, id)
+		s.mu.Unlock()
+
+		if err := s.process(id); err != nil {
+			log.Printf("[Summarize] %s: %v", id, err)
+		}
+	}
+}
+
+// process generates whatever the conversation is missing
+func (s *Summarizer) process(id string) error {
+	meta, err := s.store.GetMeta(id)
+	if err != nil {
+		return err
+	}
+	msgs, err := s.store.GetMessages(id)
+	if err != nil {
+		return err
+	}
+
+	instanceID := meta.InstanceID
+	if instanceID == "" {
+		instanceID = s.config.SummarizeInstance
+	}
+	if instanceID == "" {
+		return nil
+	}
+
+	needTitle := meta.TitleSource == "" && hasExchange(msgs)
+	needSummary := s.config.SummaryEvery > 0 && len(msgs)-meta.SummaryMessageCount >= s.config.SummaryEvery
+	if !needTitle && !needSummary {
+		return nil
+	}
+
+	var title, summary string
+	if needTitle {
+		// The first exchange is enough context for a title
+		prompt := append(toChatMessages(firstExchange(msgs)), ChatMessage{Role: "user", Content: TextContent(titlePrompt)})
+		title, err = s.complete(id, instanceID, prompt)
+		if err != nil {
+			return fmt.Errorf("title: %v", err)
+		}
+		title = cleanTitle(title)
+	}
+	if needSummary {
+		// Only send what the previous summary does not cover
+		var prompt []ChatMessage
+		if meta.Summary != "" && meta.SummaryMessageCount <= len(msgs) {
+			prompt = append(prompt, ChatMessage{Role: "system", Content: TextContent("Summary of the earlier conversation: " + meta.Summary)})
+			prompt = append(prompt, toChatMessages(msgs[meta.SummaryMessageCount:])...)
+		} else {
+			prompt = toChatMessages(msgs)
+		}
+		prompt = append(prompt, ChatMessage{Role: "user", Content: TextContent(summaryPrompt)})
+		summary, err = s.complete(id, instanceID, prompt)
+		if err != nil {
+			return fmt.Errorf("summary: %v", err)
+		}
+		summary = strings.TrimSpace(summary)
+	}
+
+	log.Printf("[Summarize] %s updated via %s (title=%q, summary=%d chars)", id, instanceID, title, len(summary))
+	return s.store.ApplySummary(id, title, summary, len(msgs))
+}
+
+// complete runs a non-streaming chat request against a bridge or the local
+// backend and records its token usage against the conversation
+func (s *Summarizer) complete(conversationID, instanceID string, messages []ChatMessage) (string, error) {
+	requestID := generateRequestID()
+	result := RelayResult{InstanceID: instanceID}
+	usage := &result.Usage
+	defer func() {
+		if *usage != (ChatUsage{}) {
+			s.usage.Record(UsageRecord{
+				RequestID:        requestID,
+				InstanceID:       result.InstanceID,
+				InstanceName:     result.InstanceName,
+				DeviceID:         "voicechat-summarizer",
+				ConversationID:   conversationID,
+				Model:            usage.Model,
+				PromptTokens:     usage.PromptTokens,
+				CompletionTokens: usage.CompletionTokens,
+				TotalTokens:      usage.TotalTokens,
+				Reported:         true,
+			})
+		}
+	}()
+
+	if isLocalTarget(instanceID, s.config) {
+		result.InstanceID = "local"
+		return s.completeLocal(messages, usage)
+	}
+
+	responseCh := make(chan string)
+	errorCh := make(chan error)
+	go s.relay.RelayChat(context.Background(), instanceID, requestID, messages, ChatOptions{User: "voicechat-summarizer"}, s.relay.Timeouts(nil), responseCh, errorCh, nil, nil, &result)
+
+	var sb strings.Builder
+	for {
+		select {
+		case delta, ok := <-responseCh:
+			if !ok {
+				return sb.String(), nil
+			}
+			sb.WriteString(delta)
+		case err, ok := <-errorCh:
+			if !ok {
+				errorCh = nil
+				continue
+			}
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+}
+
+// completeLocal calls the local OpenClaw gateway's OpenAI-compatible API without streaming
+func (s *Summarizer) completeLocal(messages []ChatMessage, usage *ChatUsage) (string, error) {
+	if s.config.LocalOpenclawURL == "" {
+		return "", fmt.Errorf("local instance not configured")
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"model":    "openclaw",
+		"stream":   false,
+		"user":     "voicechat-summarizer",
+		"messages": messages,
+	})
+	req, err := http.NewRequest("POST", s.config.LocalOpenclawURL+"/v1/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-openclaw-agent-id", "main")
+	if s.config.LocalOpenclawToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.config.LocalOpenclawToken)
+	}
+
+	client := &http.Client{Timeout: 2 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("OpenClaw HTTP %d", resp.StatusCode)
+	}
+
+	var parsed struct {
+		Model string `json:"model"`
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+			TotalTokens      int `json:"total_tokens"`
+		} `json:"usage"`
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return "", err
+	}
+	if parsed.Usage.PromptTokens+parsed.Usage.CompletionTokens > 0 {
+		*usage = ChatUsage{
+			Model:            parsed.Model,
+			PromptTokens:     parsed.Usage.PromptTokens,
+			CompletionTokens: parsed.Usage.CompletionTokens,
+			TotalTokens:      parsed.Usage.TotalTokens,
+		}
+	}
+	if len(parsed.Choices) == 0 {
+		return "", fmt.Errorf("empty completion")
+	}
+	return parsed.Choices[0].Message.Content, nil
+}
+
+// CompressContext replaces messages already covered by the conversation's
+// rolling summary with a single system message, keeping the most recent
+// ContextKeepMessages intact. Requests without a summary pass through unchanged.
+func (s *Summarizer) CompressContext(conversationID string, messages []ChatMessage) []ChatMessage {
+	keep := s.config.ContextKeepMessages
+	if conversationID == "" || keep <= 0 || len(messages) <= keep {
+		return messages
+	}
+	meta, err := s.store.GetMeta(conversationID)
+	if err != nil || meta.Summary == "" {
+		return messages
+	}
+
+	cut := len(messages) - keep
+	if cut > meta.SummaryMessageCount {
+		cut = meta.SummaryMessageCount
+	}
+	if cut <= 0 {
+		return messages
+	}
+
+	compressed := make([]ChatMessage, 0, len(messages)-cut+1)
+	compressed = append(compressed, ChatMessage{Role: "system", Content: TextContent("Summary of the earlier conversation: " + meta.Summary)})
+	compressed = append(compressed, messages[cut:]...)
+	return compressed
+}
+
+// hasExchange reports whether msgs contain a user message followed by an answer
+func hasExchange(msgs []ConversationMessage) bool {
+	return len(firstExchange(msgs)) == 2
+}
+
+// firstExchange returns the first user message and the assistant reply after it
+func firstExchange(msgs []ConversationMessage) []ConversationMessage {
+	for i, m := range msgs {
+		if m.Role != "user" || m.Content.IsEmpty() {
+			continue
+		}
+		for _, r := range msgs[i+1:] {
+			if r.Role == "assistant" && !r.Content.IsEmpty() {
+				return []ConversationMessage{m, r}
+			}
+		}
+		return []ConversationMessage{m}
+	}
+	return nil
+}
+
+func toChatMessages(msgs []ConversationMessage) []ChatMessage {
+	out := make([]ChatMessage, len(msgs))
+	for i, m := range msgs {
+		// Summaries only need the text
+		out[i] = ChatMessage{Role: m.Role, Content: TextContent(m.Content.String())}
+	}
+	return out
+}
+
+// cleanTitle strips quotes and extra lines models tend to add
+func cleanTitle(title string) string {
+	title = strings.TrimSpace(title)
+	if i := strings.IndexByte(title, '\n'); i >= 0 {
+		title = title[:i]
+	}
+	title = strings.Trim(title, "\"'“”「」 ")
+	if len([]rune(title)) > 40 {
+		title = string([]rune(title)[:40]) + "…"
+	}
+	return title
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const usageDayFormat = "2006-01-02"
+
+// UsageRecord is one chat request in the usage ledger
+type UsageRecord struct {
+	RequestID        string  `json:"requestId"`
+	Timestamp        int64   `json:"timestamp"`
+	InstanceID       string  `json:"instanceId"`
+	InstanceName     string  `json:"instanceName,omitempty"`
+	DeviceID         string  `json:"deviceId,omitempty"`
+	ClientIP         string  `json:"clientIp,omitempty"`
+	ConversationID   string  `json:"conversationId,omitempty"`
+	Model            string  `json:"model,omitempty"`
+	PromptTokens     int     `json:"promptTokens"`
+	CompletionTokens int     `json:"completionTokens"`
+	TotalTokens      int     `json:"totalTokens"`
+	Reported         bool    `json:"reported"` // false if the backend sent no usage
+	Cost             float64 `json:"cost,omitempty"`
+}
+
+// UsageTotals aggregates usage records
+type UsageTotals struct {
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"promptTokens"`
+	CompletionTokens int     `json:"completionTokens"`
+	TotalTokens      int     `json:"totalTokens"`
+	Unreported       int     `json:"unreported,omitempty"` // requests without usage info
+	Cost             float64 `json:"cost"`
+}
+
+func (t *UsageTotals) add(rec UsageRecord) {
+	t.Requests++
+	t.PromptTokens += rec.PromptTokens
+	t.CompletionTokens += rec.CompletionTokens
+	t.TotalTokens += rec.TotalTokens
+	if !rec.Reported {
+		t.Unreported++
+	}
+	t.Cost += rec.Cost
+}
+
+// modelPrice is the USD price per million tokens of a model
+type modelPrice struct {
+	Prompt     float64
+	Completion float64
+}
+
+// UsageLedger appends per-request token usage to daily JSONL files under
+// DataDir/usage and keeps today's per-device and per-IP totals in memory for
+// quotas.
+type UsageLedger struct {
+	dir    string
+	prices map[string]modelPrice
+
+	mu         sync.Mutex
+	today      string
+	quotaToday map[string]int // by usageQuotaKeys
+}
+
+// NewUsageLedger creates a usage ledger
+func NewUsageLedger(dataDir string, config *Config) *UsageLedger {
+	dir := filepath.Join(dataDir, "usage")
+	os.MkdirAll(dir, 0755)
+
+	l := &UsageLedger{
+		dir:        dir,
+		prices:     parseUsagePrices(config.UsagePrices),
+		quotaToday: make(map[string]int),
+	}
+	l.today = time.Now().Format(usageDayFormat)
+	for _, rec := range l.readDay(l.today) {
+		for _, key := range usageQuotaKeys(rec.DeviceID, rec.ClientIP) {
+			l.quotaToday[key] += rec.TotalTokens
+		}
+	}
+	return l
+}
+
+// Record appends a record to today's ledger file
+func (l *UsageLedger) Record(rec UsageRecord) {
+	if rec.Timestamp == 0 {
+		rec.Timestamp = time.Now().UnixMilli()
+	}
+	if rec.TotalTokens == 0 {
+		rec.TotalTokens = rec.PromptTokens + rec.CompletionTokens
+	}
+	rec.Cost = l.cost(rec)
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	day := time.UnixMilli(rec.Timestamp).Format(usageDayFormat)
+	if day != l.today {
+		l.today = day
+		l.quotaToday = make(map[string]int)
+	}
+	for _, key := range usageQuotaKeys(rec.DeviceID, rec.ClientIP) {
+		l.quotaToday[key] += rec.TotalTokens
+	}
+
+	f, err := os.OpenFile(l.dayPath(day), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("[Usage] Failed to write ledger: %v", err)
+		return
+	}
+	defer f.Close()
+	f.Write(append(data, '\n'))
+}
+
+// TokensToday returns the tokens used since midnight under a usageQuotaKeys key
+func (l *UsageLedger) TokensToday(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if time.Now().Format(usageDayFormat) != l.today {
+		return 0
+	}
+	return l.quotaToday[key]
+}
+
+// usageQuotaKeys are what the daily token quota is counted against: the
+// client IP and, if sent, the device. The device ID is chosen by the client,
+// so the IP is always charged too.
+func usageQuotaKeys(deviceID, clientIP string) []string {
+	var keys []string
+	if clientIP != "" {
+		keys = append(keys, "ip:"+clientIP)
+	}
+	if deviceID != "" {
+		keys = append(keys, "device:"+deviceID)
+	}
+	return keys
+}
+
+// HandleUsage GET /api/usage?from=YYYY-MM-DD&to=YYYY-MM-DD - aggregated token usage
+func (l *UsageLedger) HandleUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Default: the last 30 days
+	now := time.Now()
+	from := now.AddDate(0, 0, -29).Format(usageDayFormat)
+	to := now.Format(usageDayFormat)
+	if v := r.URL.Query().Get("from"); v != "" {
+		if _, err := time.Parse(usageDayFormat, v); err != nil {
+			http.Error(w, "from must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		from = v
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		if _, err := time.Parse(usageDayFormat, v); err != nil {
+			http.Error(w, "to must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		to = v
+	}
+
+	var total UsageTotals
+	byDay := make(map[string]*UsageTotals)
+	byInstance := make(map[string]*UsageTotals)
+	byDevice := make(map[string]*UsageTotals)
+	byConversation := make(map[string]*UsageTotals)
+	byModel := make(map[string]*UsageTotals)
+	add := func(m map[string]*UsageTotals, key string, rec UsageRecord) {
+		if key == "" {
+			key = "unknown"
+		}
+		if m[key] == nil {
+			m[key] = &UsageTotals{}
+		}
+		m[key].add(rec)
+	}
+
+	for _, day := range l.days(from, to) {
+		for _, rec := range l.readDay(day) {
+			total.add(rec)
+			add(byDay, day, rec)
+			// Bridge IDs change on reconnect; names are stable
+			instance := rec.InstanceName
+			if instance == "" {
+				instance = rec.InstanceID
+			}
+			add(byInstance, instance, rec)
+			add(byDevice, rec.DeviceID, rec)
+			add(byConversation, rec.ConversationID, rec)
+			add(byModel, rec.Model, rec)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from":           from,
+		"to":             to,
+		"total":          total,
+		"byDay":          byDay,
+		"byInstance":     byInstance,
+		"byDevice":       byDevice,
+		"byConversation": byConversation,
+		"byModel":        byModel,
+	})
+}
+
+// days lists the ledger days between from and to (inclusive) that have a file
+func (l *UsageLedger) days(from, to string) []string {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil
+	}
+	var days []string
+	for _, e := range entries {
+		day := strings.TrimSuffix(e.Name(), ".jsonl")
+		if day == e.Name() || day < from || day > to {
+			continue
+		}
+		days = append(days, day)
+	}
+	sort.Strings(days)
+	return days
+}
+
+func (l *UsageLedger) dayPath(day string) string {
+	return filepath.Join(l.dir, day+".jsonl")
+}
+
+func (l *UsageLedger) readDay(day string) []UsageRecord {
+	f, err := os.Open(l.dayPath(day))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var records []UsageRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec UsageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+// cost prices a record; models without a configured price cost nothing
+func (l *UsageLedger) cost(rec UsageRecord) float64 {
+	p, ok := l.prices[rec.Model]
+	if !ok {
+		p, ok = l.prices["*"]
+	}
+	if !ok {
+		return 0
+	}
+	return (float64(rec.PromptTokens)*p.Prompt + float64(rec.CompletionTokens)*p.Completion) / 1e6
+}
+
+// parseUsagePrices parses "model=prompt/completion,..." (USD per million
+// tokens); "*" sets the price of models not listed.
+func parseUsagePrices(s string) map[string]modelPrice {
+	prices := make(map[string]modelPrice)
+	for _, entry := range strings.Split(s, ",") {
+		model, price, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			continue
+		}
+		in, out, _ := strings.Cut(price, "/")
+		p, err1 := strconv.ParseFloat(strings.TrimSpace(in), 64)
+		c, err2 := strconv.ParseFloat(strings.TrimSpace(out), 64)
+		if err1 != nil || err2 != nil {
+			log.Printf("[Usage] Ignoring invalid price %q", entry)
+			continue
+		}
+		prices[strings.TrimSpace(model)] = modelPrice{Prompt: p, Completion: c}
+	}
+	return prices
+}
+
+// deviceID identifies the app installation making a request
+func deviceID(r *http.Request) string {
+	return strings.TrimSpace(r.Header.Get("X-Device-ID"))
+}
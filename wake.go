@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrCannotWake = errors.New("no waker or MAC address for instance")
+
+// WakeManager wakes sleeping bridges through a bridge that declared the
+// "waker" capability. MAC addresses are learned from bridge registrations
+// (persisted in DataDir/wake_macs.json) or configured with WAKE_MACS.
+type WakeManager struct {
+	bridges *BridgeManager
+	path    string
+
+	mu      sync.Mutex
+	macs    map[string]string // bridge name -> MAC
+	sent    map[string]time.Time
+	waiters map[string][]chan struct{}
+}
+
+// NewWakeManager creates a wake manager
+func NewWakeManager(dataDir string, config *Config, bridges *BridgeManager) *WakeManager {
+	wm := &WakeManager{
+		bridges: bridges,
+		path:    filepath.Join(dataDir, "wake_macs.json"),
+		macs:    make(map[string]string),
+		sent:    make(map[string]time.Time),
+		waiters: make(map[string][]chan struct{}),
+	}
+	if data, err := os.ReadFile(wm.path); err == nil {
+		json.Unmarshal(data, &wm.macs)
+	}
+	for _, entry := range strings.Split(config.WakeMACs, ",") {
+		name, mac, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			continue
+		}
+		if hw, err := net.ParseMAC(strings.TrimSpace(mac)); err == nil {
+			wm.macs[strings.TrimSpace(name)] = hw.String()
+		} else {
+			log.Printf("[Wake] Ignoring invalid MAC %q for %s", mac, name)
+		}
+	}
+	return wm
+}
+
+// BridgeRegistered remembers the bridge's MAC and releases requests waiting for it
+func (wm *WakeManager) BridgeRegistered(bridge *BridgeConnection) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	if hw, err := net.ParseMAC(bridge.MAC); err == nil && wm.macs[bridge.Name] != hw.String() {
+		wm.macs[bridge.Name] = hw.String()
+		if data, err := json.MarshalIndent(wm.macs, "", "  "); err == nil {
+			os.WriteFile(wm.path, data, 0644)
+		}
+	}
+
+	for _, ch := range wm.waiters[bridge.Name] {
+		close(ch)
+	}
+	delete(wm.waiters, bridge.Name)
+	delete(wm.sent, bridge.Name)
+}
+
+// CanWake reports whether name has a known MAC and a waker is online
+func (wm *WakeManager) CanWake(name string) bool {
+	wm.mu.Lock()
+	_, ok := wm.macs[name]
+	wm.mu.Unlock()
+	return ok && wm.waker(name) != nil
+}
+
+// Wake asks a waker bridge to send a magic packet to name. Repeated calls
+// within 30 seconds send only one packet.
+func (wm *WakeManager) Wake(name string) error {
+	wm.mu.Lock()
+	mac, ok := wm.macs[name]
+	recent := time.Since(wm.sent[name]) < 30*time.Second
+	wm.mu.Unlock()
+	if !ok {
+		return ErrCannotWake
+	}
+	if recent {
+		return nil
+	}
+
+	waker := wm.waker(name)
+	if waker == nil {
+		return ErrCannotWake
+	}
+	err := waker.Send(WakeRequestMessage{
+		Type:      MsgTypeWakeRequest,
+		RequestID: fmt.Sprintf("wake_%d", time.Now().UnixNano()),
+		Target:    name,
+		MAC:       mac,
+	})
+	if err != nil {
+		return fmt.Errorf("send wake request: %v", err)
+	}
+
+	wm.mu.Lock()
+	wm.sent[name] = time.Now()
+	wm.mu.Unlock()
+	log.Printf("[Wake] Asked %s (%s) to wake %s (%s)", waker.Name, waker.ID, name, mac)
+	return nil
+}
+
+// WaitFor blocks until a bridge called name registers, the timeout expires
+// or ctx is cancelled. It returns true if the bridge came online.
+func (wm *WakeManager) WaitFor(ctx context.Context, name string, timeout time.Duration) bool {
+	ch := make(chan struct{})
+	wm.mu.Lock()
+	wm.waiters[name] = append(wm.waiters[name], ch)
+	wm.mu.Unlock()
+
+	// It may have registered before we started waiting
+	if len(wm.bridges.Candidates(name)) > 0 {
+		return true
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	wm.mu.Lock()
+	waiters := wm.waiters[name]
+	for i, w := range waiters {
+		if w == ch {
+			wm.waiters[name] = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	wm.mu.Unlock()
+	return false
+}
+
+// waker returns the least busy online bridge with the waker capability
+func (wm *WakeManager) waker(exclude string) *BridgeConnection {
+	for _, bridge := range wm.bridges.WithCapability(CapabilityWaker) {
+		if bridge.Name != exclude {
+			return bridge
+		}
+	}
+	return nil
+}
@@ -34,6 +34,21 @@ var (
 	streamInfoCache   = make(map[string]streamInfoEntry)
 )
 
+// flushYouTubeCaches drops cached stream infos and HLS URLs so the next
+// request resolves them again, returning how many entries were dropped
+func flushYouTubeCaches() (streamInfos, hlsURLs int) {
+	streamInfoCacheMu.Lock()
+	streamInfos = len(streamInfoCache)
+	streamInfoCache = make(map[string]streamInfoEntry)
+	streamInfoCacheMu.Unlock()
+
+	liveHLSCacheMu.Lock()
+	hlsURLs = len(liveHLSCache)
+	liveHLSCache = make(map[string]liveHLSEntry)
+	liveHLSCacheMu.Unlock()
+	return streamInfos, hlsURLs
+}
+
 func getCachedStreamInfo(videoID string) (*StreamInfo, bool) {
 	streamInfoCacheMu.Lock()
 	defer streamInfoCacheMu.Unlock()